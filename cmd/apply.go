@@ -0,0 +1,64 @@
+/*
+Copyright © 2022 OmegaRogue <omegarogue@omegavoid.codes>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"omega-pkg/pkg/lang"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply [plan file]",
+	Short: "Apply a saved plan or the changes planned for the current config",
+	Long: `Run the commands of a plan. If a plan file written by "omega-pkg plan --out"
+is given, exactly the commands of that plan are run. Otherwise a new plan is
+computed from the current config and applied.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := log.Logger.WithContext(context.Background())
+		ctx = context.WithValue(ctx, lang.DryrunContextKey, viper.GetBool("dryrun"))
+
+		c := viper.Get("config").(lang.Config)
+		var plan *lang.Plan
+		var err error
+		if len(args) == 1 {
+			plan, err = lang.ReadPlan(args[0])
+		} else {
+			plan, err = c.Plan(ctx)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("plan")
+		}
+		err = recordRun(ctx, "apply", &c, func(ctx context.Context) error {
+			if err := plan.Apply(ctx); err != nil {
+				return err
+			}
+			return updateLockFile(ctx, &c)
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("apply")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2022 OmegaRogue <omegarogue@omegavoid.codes>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"omega-pkg/pkg/state"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the recorded runs",
+	Long: `List the runs recorded in the state directory, $XDG_STATE_HOME/omega-pkg.
+Every run and apply that is no dry run is recorded.`,
+	// The history only reads the state directory, so the config is not
+	// loaded, as it may not even be valid anymore.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Args:             cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStore()
+		if err != nil {
+			log.Fatal().Err(err).Msg("open state")
+		}
+		runs, err := store.Runs()
+		if err != nil {
+			log.Fatal().Err(err).Msg("read runs")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTART\tDURATION\tCOMMAND\tSTATUS\tCHANGED\tGENERATION\tCONFIG")
+		for _, run := range runs {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", run.ID, run.Start.Format(timeFormat), runDuration(run),
+				run.Command, run.Status(), len(run.Changed()), orDash(generationID(run)), shortHash(run.ConfigHash))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal().Err(err).Msg("print runs")
+		}
+	},
+}
+
+// historyShowCmd represents the history show command
+var historyShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show the details of a recorded run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatal().Str("id", args[0]).Msg("invalid run id")
+		}
+		store, err := openStore()
+		if err != nil {
+			log.Fatal().Err(err).Msg("open state")
+		}
+		run, err := store.Run(id)
+		if err != nil {
+			log.Fatal().Err(err).Msg("read run")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "run %d (%s)\n", run.ID, run.Command)
+		fmt.Fprintf(w, "  start:\t%s\n", run.Start.Format(timeFormat))
+		if !run.End.IsZero() {
+			fmt.Fprintf(w, "  end:\t%s\n", run.End.Format(timeFormat))
+		}
+		fmt.Fprintf(w, "  duration:\t%s\n", runDuration(run))
+		fmt.Fprintf(w, "  config:\t%s\n", run.ConfigHash)
+		fmt.Fprintf(w, "  status:\t%s\n", run.Status())
+		if run.Generation != 0 {
+			fmt.Fprintf(w, "  generation:\t%d\n", run.Generation)
+		}
+		if run.Error != "" {
+			fmt.Fprintf(w, "  error:\t%s\n", run.Error)
+		}
+		if changed := run.Changed(); len(changed) > 0 {
+			fmt.Fprintf(w, "  changed:\t%s\n", strings.Join(changed, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal().Err(err).Msg("print run")
+		}
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MANAGER\tACTION\tSET\tEXIT\tDURATION\tCOMMAND")
+		for _, command := range run.Commands {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", orDash(command.Manager), command.Action, orDash(command.Set),
+				command.ExitCode, command.Duration.Round(time.Millisecond), strings.Join(command.Argv, " "))
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal().Err(err).Msg("print commands")
+		}
+	},
+}
+
+func runDuration(run *state.Run) string {
+	if run.End.IsZero() {
+		return "-"
+	}
+	return run.End.Sub(run.Start).Round(time.Millisecond).String()
+}
+
+func generationID(run *state.Run) string {
+	if run.Generation == 0 {
+		return ""
+	}
+	return strconv.Itoa(run.Generation)
+}
+
+func shortHash(hash string) string {
+	hash = strings.TrimPrefix(hash, "sha256:")
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	historyCmd.AddCommand(historyShowCmd)
+	rootCmd.AddCommand(historyCmd)
+}
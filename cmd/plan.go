@@ -0,0 +1,63 @@
+/*
+Copyright © 2022 OmegaRogue <omegarogue@omegavoid.codes>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"omega-pkg/pkg/lang"
+	"os"
+)
+
+var planOut string
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show the changes a run would make",
+	Long: `Query every manager for its installed packages and compare them with
+the configured sets. The resulting plan lists the packages to install,
+the packages already present and the packages to remove, together with
+the refresh, update and clean steps of each manager.
+
+The plan can be saved with --out and run later with "omega-pkg apply <file>".
+Plans using the values of sensitive variables cannot be saved.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := log.Logger.WithContext(context.Background())
+		c := viper.Get("config").(lang.Config)
+		plan, err := c.Plan(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("plan")
+		}
+		if err := plan.Write(os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("print plan")
+		}
+		if planOut != "" {
+			if err := plan.Save(planOut); err != nil {
+				log.Fatal().Err(err).Msg("save plan")
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+
+	planCmd.Flags().StringVarP(&planOut, "out", "o", "", "save the plan to a file for apply")
+}
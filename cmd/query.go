@@ -0,0 +1,76 @@
+/*
+Copyright © 2022 OmegaRogue <omegarogue@omegavoid.codes>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"omega-pkg/pkg/lang"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// queryScope is the scope the query action runs in.
+var queryScope string
+
+// queryCmd represents the query command
+var queryCmd = &cobra.Command{
+	Use:   "query <manager> <action> [packages...]",
+	Short: "Run a query action of a manager and print the parsed packages",
+	Long: fmt.Sprintf(`Run one of the read-only actions (%s)
+of a custom_manager and print the packages parsed from its output.`, strings.Join(lang.QueryActions, ", ")),
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := log.Logger.WithContext(context.Background())
+		c := viper.Get("config").(lang.Config)
+		hclCtx := viper.Get("ctx").(*hcl.EvalContext)
+
+		customManager, ok := c.CustomManagerMap[args[0]]
+		if !ok {
+			log.Fatal().Str("manager", args[0]).Msg("manager does not exist")
+		}
+		if !lang.IsQueryAction(args[1]) {
+			log.Fatal().Str("action", args[1]).Msg("action is no query action")
+		}
+		if diags := customManager.PrepareAction(hclCtx, args[1]); diags.HasErrors() {
+			log.Fatal().Err(diags).Msg("prepare action")
+		}
+		infos, err := customManager.Query(ctx, args[1], queryScope, args[2:]...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("query")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tLATEST\tREPOSITORY\tDESCRIPTION")
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Name, info.Version, info.Latest, info.Repository, info.Description)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatal().Err(err).Msg("print packages")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().StringVar(&queryScope, "scope", "", "scope of the manager to query, its first scope by default")
+}
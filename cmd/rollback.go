@@ -0,0 +1,113 @@
+/*
+Copyright © 2022 OmegaRogue <omegarogue@omegavoid.codes>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"omega-pkg/pkg/lang"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var rollbackYes bool
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [generation]",
+	Short: "Return the installed packages to a recorded generation",
+	Long: `Every successful run and apply records the installed packages of each
+manager as a numbered generation, see "omega-pkg history". rollback compares the
+installed packages with a generation, the one before the latest by default,
+prints the plan and, once confirmed, runs the install and remove actions to
+return to it. Only explicitly installed packages, or the packages declared by
+the sets of managers without a list_explicit action, are recorded and removed,
+so dependencies are left to the package managers.
+
+Packages whose version changed are installed pinned to the version of the
+generation, using the downgrade action where a manager has one. Managers that
+cannot pin versions keep the installed version.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := log.Logger.WithContext(context.Background())
+		ctx = context.WithValue(ctx, lang.DryrunContextKey, viper.GetBool("dryrun"))
+		c := viper.Get("config").(lang.Config)
+
+		store, err := openStore()
+		if err != nil {
+			log.Fatal().Err(err).Msg("open state")
+		}
+		ids, err := store.Generations()
+		if err != nil {
+			log.Fatal().Err(err).Msg("read generations")
+		}
+		var id int
+		switch {
+		case len(args) == 1:
+			if id, err = strconv.Atoi(args[0]); err != nil {
+				log.Fatal().Str("generation", args[0]).Msg("invalid generation")
+			}
+		case len(ids) < 2:
+			log.Fatal().Msg("no previous generation to roll back to")
+		default:
+			id = ids[len(ids)-2]
+		}
+		generation, err := store.Generation(id)
+		if err != nil {
+			log.Fatal().Err(err).Msg("read generation")
+		}
+
+		plan, err := c.PlanRollback(ctx, generation)
+		if err != nil {
+			log.Fatal().Err(err).Msg("plan rollback")
+		}
+		if err := plan.Write(os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("print plan")
+		}
+		if !viper.GetBool("dryrun") && !rollbackYes && !confirm(fmt.Sprintf("Roll back to generation %d?", id)) {
+			log.Info().Msg("rollback cancelled")
+			return
+		}
+		err = recordRun(ctx, "rollback", &c, plan.Apply)
+		if err != nil {
+			log.Fatal().Err(err).Msg("rollback")
+		}
+		log.Info().Int("generation", id).Msg("rolled back")
+	},
+}
+
+// confirm asks question on stderr and reports whether the answer read from
+// stdin is yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().BoolVarP(&rollbackYes, "yes", "y", false, "run the rollback without asking for confirmation")
+}
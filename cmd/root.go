@@ -19,24 +19,27 @@ package cmd
 import (
 	"context"
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/ext/userfunc"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/zcalusic/sysinfo"
-	"github.com/zclconf/go-cty/cty"
-	"github.com/zclconf/go-cty/cty/function"
 	"omega-pkg/internal/managers"
 	"omega-pkg/pkg/lang"
+	"omega-pkg/pkg/state"
 	"omega-pkg/pkg/zerolog_extension"
 	"os"
+	"strings"
 )
 
-var cfgFile string
+var (
+	cfgFile  string
+	varFlags []string
+	varFiles []string
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -48,6 +51,10 @@ examples and usage of using your application. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
+	// Subcommands that do not use the config override this hook.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		initConfig()
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
@@ -61,7 +68,13 @@ to quickly create a Cobra application.`,
 		ctx := log.Logger.WithContext(context.Background())
 		ctx = context.WithValue(ctx, lang.DryrunContextKey, viper.GetBool("dryrun"))
 		c := viper.Get("config").(lang.Config)
-		if err := c.Run(ctx); err != nil {
+		err := recordRun(ctx, "run", &c, func(ctx context.Context) error {
+			if err := c.Run(ctx); err != nil {
+				return err
+			}
+			return updateLockFile(ctx, &c)
+		})
+		if err != nil {
 			log.Fatal().Err(err).Msg("run")
 		}
 		//data, err = json.MarshalIndent(&c, "", "  ")
@@ -76,6 +89,7 @@ to quickly create a Cobra application.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	rootCmd.SetArgs(normalizeArgs(os.Args[1:]))
 	err := rootCmd.Execute()
 	if err != nil {
 		log.Fatal().Err(err).Msg("run")
@@ -83,24 +97,47 @@ func Execute() {
 	}
 }
 
+// normalizeArgs rewrites the single-dash flags -var and -var-file, as
+// terraform spells them, to --var and --var-file, which pflag would otherwise
+// read as the shorthand -v. Arguments after -- are left alone.
+func normalizeArgs(args []string) []string {
+	normalized := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(normalized, args[i:]...)
+		}
+		for _, name := range []string{"var", "var-file"} {
+			if arg == "-"+name || strings.HasPrefix(arg, "-"+name+"=") {
+				arg = "-" + arg
+				break
+			}
+		}
+		normalized = append(normalized, arg)
+	}
+	return normalized
+}
+
 func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
-	cobra.OnInitialize(initConfig)
-
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.omega-pkg.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file or directory (default searches $XDG_CONFIG_HOME/omega-pkg, /etc/omega-pkg and the working directory)")
 
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	rootCmd.Flags().BoolP("dryrun", "d", false, "print commands to run to output")
-	err := viper.BindPFlag("dryrun", rootCmd.Flags().Lookup("dryrun"))
+	rootCmd.PersistentFlags().StringArrayVar(&varFlags, "var", nil, "set a variable as name=value, also accepted as -var, overrides var files and OMEGA_PKG_VAR_<name> environment variables")
+	rootCmd.PersistentFlags().StringArrayVar(&varFiles, "var-file", nil, "load variable values from an HCL or JSON file, also accepted as -var-file, overrides OMEGA_PKG_VAR_<name> environment variables")
+	rootCmd.PersistentFlags().BoolP("dryrun", "d", false, "print commands to run to output")
+	err := viper.BindPFlag("dryrun", rootCmd.PersistentFlags().Lookup("dryrun"))
 	if err != nil {
 		log.Fatal().Err(err).Msg("bind flag dryrun")
 	}
+	rootCmd.PersistentFlags().Bool("locked", false, "install the versions of "+lang.LockFileName+" and fail if the installed versions drift from them")
+	err = viper.BindPFlag("locked", rootCmd.PersistentFlags().Lookup("locked"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("bind flag locked")
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -108,32 +145,29 @@ func initConfig() {
 
 	////var c lang.Config
 	////moreDiags := gohcl.DecodeBody(f.Body, nil, &c)
-	parser := hclparse.NewParser()
-	base, baseDiags := parser.ParseHCL(managers.Base, "base.hcl")
+	paths, err := lang.FindConfigFiles(cfgFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("find config files")
+	}
 
-	f, diags := parser.ParseHCLFile("server.hcl")
+	parser := hclparse.NewParser()
+	base, diags := parser.ParseHCL(managers.Base, "base.hcl")
 
-	diags = append(diags, baseDiags...)
+	bodies, fileDiags := lang.ParseConfigFiles(parser, paths)
+	diags = append(diags, fileDiags...)
 
-	body := hcl.MergeBodies([]hcl.Body{base.Body, f.Body})
+	body := hcl.MergeBodies(append([]hcl.Body{base.Body}, bodies...))
 	var c lang.Config
 	ctx, err := lang.BuildGlobalContext()
 	if err != nil {
 		log.Fatal().Err(err).Msg("Error build global hcl context")
 	}
 
-	userfuncs, remain, funcDiags := userfunc.DecodeUserFunctions(body, "func", func() *hcl.EvalContext { return ctx })
-	diags = append(diags, funcDiags...)
-
-	ctx.Functions = lo.Assign[string, function.Function](userfuncs, ctx.Functions)
-
-	locals, remain, localDiags := lang.DecodeLocals(remain, ctx)
-	diags = append(diags, localDiags...)
-	ctx.Variables["local"] = cty.ObjectVal(locals)
+	values, valueDiags := lang.CollectVariableValues(parser, os.Environ(), varFiles, varFlags)
+	diags = append(diags, valueDiags...)
 
-	vars, remain, varDiags := lang.DecodeVariable(remain, ctx)
-	diags = append(diags, varDiags...)
-	ctx.Variables["vars"] = cty.ObjectVal(vars)
+	vars, modules, remain, globalDiags := lang.DecodeGlobals(lang.NewModuleLoader(parser), body, ctx, values)
+	diags = append(diags, globalDiags...)
 
 	bodyDiags := gohcl.DecodeBody(remain, ctx, &c)
 	diags = append(diags, bodyDiags...)
@@ -145,21 +179,100 @@ func initConfig() {
 		true,           // generate colored/highlighted output
 	)
 
+	c.Sensitive = vars.Sensitive()
+	c.Modules = modules
 	validationDiags := c.Validate(ctx)
 	diags = append(diags, validationDiags...)
 
+	viper.Set("confighash", lang.ConfigHash(parser.Files()))
+
+	lockPath := lang.LockFilePath(paths)
+	if viper.GetBool("locked") {
+		lock, lockDiags := lang.ReadLockFile(parser, lockPath)
+		diags = append(diags, lockDiags...)
+		if !diags.HasErrors() {
+			diags = append(diags, c.ApplyLock(log.Logger.WithContext(context.Background()), lock)...)
+		}
+		viper.Set("lock", lock)
+	}
+
 	if err := wr.WriteDiagnostics(diags); err != nil {
 		log.Fatal().Err(err).Msg("Error writing diagnostics")
 	}
+	if diags.HasErrors() {
+		log.Fatal().Msg("invalid configuration")
+	}
 	viper.Set("config", c)
 	viper.Set("ctx", ctx)
-	//if cfgFile != "" {
-	//	viper.Set(cfgFile)
-	//	// Use config file from the flag.
-	//	//viper.SetConfigFile(cfgFile)
-	//} else {
-	//	// Find home directory.
-	//	home, err := os.UserHomeDir()
-	//	cobra.CheckErr(err)
-	//}
+	viper.Set("lockfile", lockPath)
+}
+
+// updateLockFile records the installed versions in the lock file after a
+// successful run. In locked mode the lock file is kept and the installed
+// versions are checked against it instead.
+func updateLockFile(ctx context.Context, c *lang.Config) error {
+	if viper.GetBool("dryrun") {
+		return nil
+	}
+	if viper.GetBool("locked") {
+		return errors.Wrap(c.CheckLock(ctx, viper.Get("lock").(*lang.LockFile)), "check lock file")
+	}
+	lock, err := c.Lock(ctx)
+	if err != nil {
+		return errors.Wrap(err, "lock installed versions")
+	}
+	path := viper.GetString("lockfile")
+	if err := lock.Write(path); err != nil {
+		return err
+	}
+	log.Info().Str("path", path).Msg("wrote lock file")
+	return nil
+}
+
+// recordRun calls fn with a context recording the commands it runs and saves
+// the record to the state store. After a successful run the installed
+// packages of c are recorded as a new generation. Dry runs are not recorded.
+func recordRun(ctx context.Context, command string, c *lang.Config, fn func(ctx context.Context) error) error {
+	if viper.GetBool("dryrun") {
+		return fn(ctx)
+	}
+	store, err := openStore()
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to record run")
+		return fn(ctx)
+	}
+	run := state.NewRun(command, viper.GetString("confighash"))
+	if err := store.SaveRun(run); err != nil {
+		log.Warn().Err(err).Msg("unable to record run")
+		return fn(ctx)
+	}
+	err = fn(context.WithValue(ctx, lang.RunContextKey, run))
+	run.Finish(err)
+	if err == nil {
+		if generation, snapshotErr := c.Snapshot(ctx); snapshotErr != nil {
+			log.Warn().Err(snapshotErr).Msg("unable to record generation")
+		} else {
+			generation.Run = run.ID
+			generation.Time = run.End
+			if saveErr := store.SaveGeneration(generation); saveErr != nil {
+				log.Warn().Err(saveErr).Msg("unable to record generation")
+			} else {
+				run.Generation = generation.ID
+			}
+		}
+	}
+	if saveErr := store.SaveRun(run); saveErr != nil {
+		log.Warn().Err(saveErr).Msg("unable to record run")
+	} else {
+		log.Info().Int("id", run.ID).Int("generation", run.Generation).Msg("recorded run")
+	}
+	return err
+}
+
+func openStore() (*state.Store, error) {
+	dir, err := state.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return state.Open(dir), nil
 }
@@ -0,0 +1,83 @@
+// Custom managers describe how to drive a package manager.
+
+// Actions of a manager with as_user run as the user invoking omega-pkg
+// through sudo, with the directories of path prepended to PATH. The package
+// attribute formats every package passed to the command, with pkg set to its
+// name and version to its pinned version, or "".
+custom_manager "uv" {
+  cmd     = "uv"
+  as_user = true
+  path    = ["~/.local/bin"]
+  action "install" {
+    flags   = ["tool", "install"]
+    package = version == "" ? pkg : "${pkg}==${version}"
+  }
+  action "remove" {
+    flags = ["tool", "uninstall"]
+  }
+}
+
+// Managers without a package attribute pin packages with version_format,
+// evaluated with name and version. detect tells whether the manager is the
+// package manager of the host, which manager "system" resolves to.
+custom_manager "rpm-ostree" {
+  cmd            = "rpm-ostree"
+  detect         = command_exists("rpm-ostree")
+  version_format = "${name}-${version}"
+  action "install" {
+    flags = ["install", "--idempotent"]
+  }
+  action "remove" {
+    flags = ["uninstall"]
+  }
+  // rollback installs pinned packages with downgrade instead of install if
+  // the manager has it.
+  action "downgrade" {
+    flags = ["install", "--idempotent", "--allow-inactive"]
+  }
+  // Inline scripts run with /bin/sh -c and receive the packages as
+  // positional parameters unless they reference pkgs.
+  action "is_installed" {
+    inline = ["rpm -q --qf '%%{NAME} %%{VERSION}-%%{RELEASE}\\n' \"$@\" || true"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+}
+
+// scopes lists the installations a manager can act on, the first being the
+// default. Sets and repositories choose one with scope, actions read it as
+// scope. The scope named by user_scope runs as the invoking user.
+custom_manager "snap-like" {
+  cmd        = "flatpak"
+  scopes     = ["system", "user"]
+  user_scope = "user"
+  action "install" {
+    flags = ["install", "--noninteractive", "--${scope}"]
+  }
+  action "remove" {
+    flags = ["uninstall", "--noninteractive", "--${scope}"]
+  }
+}
+
+// extends inherits cmd, flags, env and the actions of another manager, its
+// own actions replacing those of the same type.
+custom_manager "yay" {
+  extends = "paru"
+  cmd     = "yay"
+}
+
+// Disabled managers are hidden, e.g. to drop a built-in one.
+custom_manager "nix-env" {
+  disabled = true
+}
+
+// An override block patches the custom_manager of the same name: its
+// attributes replace those of the manager and its action blocks replace the
+// actions of the same type.
+override "pacman" {
+  flags = ["--noconfirm", "--color=never"]
+  action "install" {
+    flags = ["-S", "--needed", "--asdeps"]
+  }
+}
@@ -0,0 +1,14 @@
+variable "editor" {
+  type    = string
+  default = "nano"
+}
+
+manager "system" {
+  set "install" {
+    packages = [vars.editor]
+  }
+}
+
+output "editor" {
+  value = vars.editor
+}
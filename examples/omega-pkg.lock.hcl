@@ -0,0 +1,23 @@
+# This file is maintained automatically by omega-pkg.
+# Manual edits may be lost in future updates.
+
+# The lock file records the versions installed by the sets of a config, with
+# a manager block for every scope of a manager. Packages that were not
+# installed when the lock was written have no version.
+
+manager "flatpak" {
+  custom_manager = "flatpak"
+  scope          = "user"
+  package "org.mozilla.firefox" {
+    version = "125.0"
+  }
+  package "org.videolan.VLC" {
+  }
+}
+
+manager "system" {
+  custom_manager = "apt"
+  package "git" {
+    version = "1:2.39.2-1.1"
+  }
+}
@@ -0,0 +1,67 @@
+// manager "system" resolves to the custom_manager detected as the package
+// manager of the host, e.g. pacman on Arch and apt on Debian. A
+// custom_manager named system disables the detection.
+manager "system" {
+  // env, working_dir and clean_env are inherited from the custom_manager
+  // through the action and manager to the set, inner blocks overriding outer
+  // ones.
+  env = {
+    DEBIAN_FRONTEND = "noninteractive"
+  }
+  // Sets with logical = true map their packages through package blocks.
+  set "install" {
+    logical  = true
+    packages = ["fd"]
+  }
+  // Packages may pin a version, which may be a glob pattern, and pass extra
+  // flags to their command.
+  set "install" {
+    packages = [
+      "git",
+      { name = "nodejs", version = "18.*" },
+      { name = "linux-headers", flags = ["--no-install-recommends"] },
+    ]
+  }
+}
+
+// A package block names a package per custom_manager. A list maps it to
+// several packages, an empty list to none.
+package "fd" {
+  pacman = "fd"
+  apt    = "fd-find"
+}
+
+// Every attribute of a constraints block is a condition that must be true
+// for the block declaring it to apply. They are evaluated at run time and
+// can use sysinfo, variant, vars, local and all functions.
+manager "flatpak" {
+  constraints {
+    is_arch     = os_release_id() == "arch"
+    has_flatpak = command_exists("flatpak")
+  }
+  set "install" {
+    scope    = "user"
+    packages = ["org.mozilla.firefox"]
+  }
+}
+
+// An exclusive manager block removes the explicitly installed packages no
+// set declares, except those matching the glob patterns of its ignore
+// blocks. Only plan and apply remove them.
+manager "pacman" {
+  exclusive = true
+  ignore {
+    packages = ["base", "linux*"]
+  }
+  set "install" {
+    packages = ["git"]
+  }
+}
+
+// All attributes of a module block but source set the variables of the
+// module. A relative source is resolved against the directory of this file
+// and the outputs of the module are available as module.<name>.
+module "dev-tools" {
+  source = "./modules/dev"
+  editor = "vim"
+}
@@ -16,10 +16,76 @@ custom_manager "paru" {
   action "update" {
     flags = ["-Su"]
   }
+  action "list_installed" {
+    flags = ["-Q"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    flags = ["-Qqe"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "is_installed" {
+    flags = ["-Q"]
+    output {
+      columns = ["name", "version"]
+      exit_codes = [1]
+    }
+  }
+  action "outdated" {
+    flags = ["-Qu"]
+    output {
+      regex = "^(?P<name>\\S+) (?P<version>\\S+) -> (?P<latest>\\S+)"
+      exit_codes = [1]
+    }
+  }
+  action "search" {
+    flags = ["-Ss"]
+    output {
+      regex = "^(?P<repository>[^/\\s]+)/(?P<name>\\S+) (?P<version>\\S+).*\\n[ \\t]+(?P<description>.*)$"
+      exit_codes = [1]
+    }
+  }
+  action "info" {
+    flags = ["-Si"]
+    output {
+      regex = "^Repository +: (?P<repository>.*)\\nName +: (?P<name>.*)\\nVersion +: (?P<version>.*)\\nDescription +: (?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["pacman-conf --repo-list"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "import_key" {
+    inline = [
+      "set -e",
+      "pacman-key --recv-keys '${repo.key}'",
+      "pacman-key --lsign-key '${repo.key}'",
+    ]
+  }
+  action "add_repo" {
+    inline = [
+      "set -e",
+      "mkdir -p /etc/pacman.d/omega-pkg",
+      "printf '[%s]\\nServer = %s\\n' '${repo.name}' '${repo.url}' > '/etc/pacman.d/omega-pkg/${repo.name}.conf'",
+      "grep -qxF 'Include = /etc/pacman.d/omega-pkg/*.conf' /etc/pacman.conf || echo 'Include = /etc/pacman.d/omega-pkg/*.conf' >> /etc/pacman.conf",
+    ]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/pacman.d/omega-pkg/${repo.name}.conf'"]
+  }
 }
 
 custom_manager "pacman" {
   cmd = "pacman"
+  detect = command_exists("pacman") && fileexists("/etc/pacman.conf")
+  // pacman only installs the version of the sync database, so it has no
+  // version_format and pinned packages are rejected.
   flags = ["--noconfirm"]
   action "clean" {
     flags = ["-Sc"]
@@ -36,13 +102,78 @@ custom_manager "pacman" {
   action "update" {
     flags = ["-Su"]
   }
+  action "list_installed" {
+    flags = ["-Q"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    flags = ["-Qqe"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "is_installed" {
+    flags = ["-Q"]
+    output {
+      columns = ["name", "version"]
+      exit_codes = [1]
+    }
+  }
+  action "outdated" {
+    flags = ["-Qu"]
+    output {
+      regex = "^(?P<name>\\S+) (?P<version>\\S+) -> (?P<latest>\\S+)"
+      exit_codes = [1]
+    }
+  }
+  action "search" {
+    flags = ["-Ss"]
+    output {
+      regex = "^(?P<repository>[^/\\s]+)/(?P<name>\\S+) (?P<version>\\S+).*\\n[ \\t]+(?P<description>.*)$"
+      exit_codes = [1]
+    }
+  }
+  action "info" {
+    flags = ["-Si"]
+    output {
+      regex = "^Repository +: (?P<repository>.*)\\nName +: (?P<name>.*)\\nVersion +: (?P<version>.*)\\nDescription +: (?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["pacman-conf --repo-list"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "import_key" {
+    inline = [
+      "set -e",
+      "pacman-key --recv-keys '${repo.key}'",
+      "pacman-key --lsign-key '${repo.key}'",
+    ]
+  }
+  action "add_repo" {
+    inline = [
+      "set -e",
+      "mkdir -p /etc/pacman.d/omega-pkg",
+      "printf '[%s]\\nServer = %s\\n' '${repo.name}' '${repo.url}' > '/etc/pacman.d/omega-pkg/${repo.name}.conf'",
+      "grep -qxF 'Include = /etc/pacman.d/omega-pkg/*.conf' /etc/pacman.conf || echo 'Include = /etc/pacman.d/omega-pkg/*.conf' >> /etc/pacman.conf",
+    ]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/pacman.d/omega-pkg/${repo.name}.conf'"]
+  }
 }
 
 custom_manager "apk" {
   cmd = "apk"
+  detect = command_exists("apk") && fileexists("/etc/alpine-release")
+  version_format = "${name}=${version}"
   flags = ["--no-cache"]
   action "clean" {
-    flags = ["-Sc"]
+    inline = ["rm -rf /var/cache/apk/*"]
   }
   action "install" {
     flags = ["add"]
@@ -56,18 +187,78 @@ custom_manager "apk" {
   action "update" {
     flags = ["upgrade"]
   }
+  action "list_installed" {
+    flags = ["info", "-v"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+-r\\d+)$"
+    }
+  }
+  action "list_explicit" {
+    // world holds the packages added explicitly, possibly with a constraint.
+    inline = ["cat /etc/apk/world"]
+    output {
+      regex = "^(?P<name>[^=<>~\\s]+)"
+    }
+  }
+  action "is_installed" {
+    flags = ["info", "-e"]
+    output {
+      columns = ["name"]
+      exit_codes = [1]
+    }
+  }
+  action "outdated" {
+    flags = ["version", "-l", "<"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+-r\\d+)[ \\t]+<[ \\t]+(?P<latest>\\S+)"
+    }
+  }
+  action "search" {
+    flags = ["search", "-v"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+-r\\d+) - (?P<description>.*)$"
+    }
+  }
+  action "info" {
+    flags = ["info", "-d"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+-r\\d+) description:\\n(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["grep -v '^[[:space:]]*\\(#\\|$\\)' /etc/apk/repositories"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "import_key" {
+    inline = ["wget -qO '/etc/apk/keys/${repo.name}.rsa.pub' '${repo.key}'"]
+  }
+  action "add_repo" {
+    inline = ["grep -qxF '${repo.url}' /etc/apk/repositories || echo '${repo.url}' >> /etc/apk/repositories"]
+  }
+  action "remove_repo" {
+    inline = ["grep -vxF '${repo.url}' /etc/apk/repositories > /etc/apk/repositories.new; mv /etc/apk/repositories.new /etc/apk/repositories"]
+  }
 }
 
-
 custom_manager "apt" {
   cmd = "apt-get"
-  flags = "-y"
+  detect = command_exists("apt-get") && fileexists("/etc/debian_version")
+  version_format = "${name}=${version}"
+  flags = ["-y"]
+  env = {
+    DEBIAN_FRONTEND = "noninteractive"
+  }
   action "clean" {
     flags = ["clean"]
   }
   action "install" {
     flags = ["install"]
   }
+  action "downgrade" {
+    flags = ["install", "--allow-downgrades"]
+  }
   action "remove" {
     flags = ["remove", "--auto-remove"]
   }
@@ -77,4 +268,1023 @@ custom_manager "apt" {
   action "update" {
     flags = ["dist-upgrade"]
   }
-}
\ No newline at end of file
+  action "list_installed" {
+    inline = ["dpkg-query -W -f='$${db:Status-Abbrev} $${Package} $${Version}\\n' | awk '$1 == \"ii\" { print $2, $3 }'"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    inline = ["apt-mark showmanual"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "is_installed" {
+    inline = ["dpkg-query -W -f='$${db:Status-Abbrev} $${Package} $${Version}\\n' \"$@\" 2>/dev/null | awk '$1 == \"ii\" { print $2, $3 }'"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "outdated" {
+    inline = ["apt list --upgradable 2>/dev/null"]
+    output {
+      regex = "^(?P<name>[^/\\s]+)/\\S+ (?P<latest>\\S+) \\S+ \\[upgradable from: (?P<version>[^\\]]+)\\]$"
+    }
+  }
+  action "search" {
+    inline = ["apt-cache search --names-only \"$@\""]
+    output {
+      regex = "^(?P<name>\\S+) - (?P<description>.*)$"
+    }
+  }
+  action "info" {
+    inline = ["apt-cache show --no-all-versions \"$@\" | grep -E '^(Package|Version|Description(-en)?):'"]
+    output {
+      regex = "^Package: (?P<name>.*)\\nVersion: (?P<version>.*)\\nDescription(?:-en)?: (?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["for f in /etc/apt/sources.list.d/*.list; do [ -e \"$f\" ] && basename \"$f\" .list; done; true"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "import_key" {
+    inline = [
+      "set -e",
+      "install -d -m 0755 /etc/apt/keyrings",
+      "curl -fsSL '${repo.key}' | gpg --dearmor --yes -o '/etc/apt/keyrings/${repo.name}.gpg'",
+    ]
+  }
+  action "add_repo" {
+    inline = ["echo '${repo.type == "" ? "deb" : repo.type} ${repo.key == "" ? "" : "[signed-by=/etc/apt/keyrings/${repo.name}.gpg] "}${repo.url}' > '/etc/apt/sources.list.d/${repo.name}.list'"]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/apt/sources.list.d/${repo.name}.list' '/etc/apt/keyrings/${repo.name}.gpg'"]
+  }
+}
+
+custom_manager "dnf" {
+  cmd = "dnf"
+  detect = command_exists("dnf")
+  version_format = "${name}-${version}"
+  flags = ["-y"]
+  action "clean" {
+    flags = ["clean", "all"]
+  }
+  action "install" {
+    flags = ["install"]
+  }
+  action "downgrade" {
+    flags = ["downgrade"]
+  }
+  action "remove" {
+    flags = ["remove"]
+  }
+  action "refresh" {
+    flags = ["makecache", "--refresh"]
+  }
+  action "update" {
+    flags = ["upgrade"]
+  }
+  action "list_installed" {
+    inline = ["rpm -qa --qf '%%{NAME} %%{VERSION}-%%{RELEASE}\\n'"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    flags = ["-q", "repoquery", "--userinstalled", "--qf", "%%{name}"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "is_installed" {
+    // rpm -q exits with the number of packages not installed, so the
+    // installed packages are filtered instead, keeping rpm's own failures.
+    inline = [<<-EOT
+      installed=$(rpm -qa --qf '%%{NAME} %%{VERSION}-%%{RELEASE}\n') || exit
+      printf '%s\n' "$installed" | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' "$@"
+    EOT
+    ]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "outdated" {
+    flags = ["-q", "check-update"]
+    output {
+      regex = "^(?P<name>\\S+)\\.[^.\\s]+[ \\t]+(?P<latest>\\S+)[ \\t]+(?P<repository>\\S+)$"
+      exit_codes = [100]
+    }
+  }
+  action "search" {
+    flags = ["-q", "search"]
+    output {
+      regex = "^(?P<name>\\S+)\\.[^.\\s]+ : (?P<description>.*)$"
+    }
+  }
+  action "info" {
+    flags = ["-q", "info"]
+    output {
+      regex = "^Name +: (?P<name>.*)\\n(?:.*\\n)*?Version +: (?P<version>.*)\\n(?:.*\\n)*?Repository +: (?P<repository>.*)\\n(?:.*\\n)*?Summary +: (?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    flags = ["-q", "repolist", "--enabled"]
+    output {
+      columns = ["name"]
+      skip = 1
+    }
+  }
+  action "import_key" {
+    inline = ["rpm --import '${repo.key}'"]
+  }
+  action "add_repo" {
+    inline = ["printf '[%s]\\nname=%s\\nbaseurl=%s\\nenabled=1\\ngpgcheck=%s\\n' '${repo.name}' '${repo.name}' '${repo.url}' '${repo.key == "" ? 0 : 1}' > '/etc/yum.repos.d/${repo.name}.repo'"]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/yum.repos.d/${repo.name}.repo'"]
+  }
+}
+
+custom_manager "zypper" {
+  cmd = "zypper"
+  detect = command_exists("zypper")
+  version_format = "${name}=${version}"
+  flags = ["--non-interactive"]
+  action "clean" {
+    flags = ["clean", "--all"]
+  }
+  action "install" {
+    flags = ["install"]
+  }
+  action "downgrade" {
+    flags = ["install", "--oldpackage"]
+  }
+  action "remove" {
+    flags = ["remove", "--clean-deps"]
+  }
+  action "refresh" {
+    flags = ["refresh"]
+  }
+  action "update" {
+    flags = ["update"]
+  }
+  action "list_installed" {
+    inline = ["rpm -qa --qf '%%{NAME} %%{VERSION}-%%{RELEASE}\\n'"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  // zypper records the packages installed on request, the others were pulled
+  // in as dependencies.
+  action "list_explicit" {
+    flags = ["--quiet", "packages", "--installed-only", "--userinstalled"]
+    output {
+      regex = "^i\\+? +\\| (?P<repository>.*?) +\\| (?P<name>\\S+) +\\| (?P<version>\\S+) +\\|"
+    }
+  }
+  action "is_installed" {
+    // rpm -q exits with the number of packages not installed, so the
+    // installed packages are filtered instead, keeping rpm's own failures.
+    inline = [<<-EOT
+      installed=$(rpm -qa --qf '%%{NAME} %%{VERSION}-%%{RELEASE}\n') || exit
+      printf '%s\n' "$installed" | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' "$@"
+    EOT
+    ]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "outdated" {
+    flags = ["--quiet", "list-updates"]
+    output {
+      regex = "^v +\\| (?P<repository>.*?) +\\| (?P<name>\\S+) +\\| (?P<version>\\S+) +\\| (?P<latest>\\S+) +\\|"
+    }
+  }
+  action "search" {
+    flags = ["--quiet", "search", "--type", "package"]
+    output {
+      regex = "^[ iv+]*\\| (?P<name>\\S+) +\\| (?P<description>.*?) +\\| package"
+      exit_codes = [104]
+    }
+  }
+  action "info" {
+    flags = ["--quiet", "info"]
+    output {
+      regex = "^Repository +: (?P<repository>.*)\\nName +: (?P<name>.*)\\nVersion +: (?P<version>.*)\\n(?:.*\\n)*?Summary +: (?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    flags = ["--quiet", "repos"]
+    output {
+      regex = "^ *\\d+ +\\| (?P<name>\\S+) +\\|"
+    }
+  }
+  action "import_key" {
+    inline = ["rpm --import '${repo.key}'"]
+  }
+  action "add_repo" {
+    flags = ["addrepo", "--refresh", repo.url, repo.name]
+  }
+  action "remove_repo" {
+    flags = ["removerepo", repo.name]
+  }
+}
+
+custom_manager "xbps" {
+  cmd = "xbps-install"
+  detect = command_exists("xbps-install")
+  flags = ["-y"]
+  action "clean" {
+    cmd = "xbps-remove"
+    flags = ["-O"]
+  }
+  action "install" {
+  }
+  action "remove" {
+    cmd = "xbps-remove"
+    flags = ["-R"]
+  }
+  action "refresh" {
+    flags = ["-S"]
+  }
+  action "update" {
+    flags = ["-u"]
+  }
+  action "list_installed" {
+    inline = ["xbps-query -l"]
+    output {
+      regex = "^ii (?P<name>\\S+)-(?P<version>[^-\\s]+_\\d+) "
+    }
+  }
+  action "list_explicit" {
+    inline = ["xbps-query -m"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+_\\d+)$"
+    }
+  }
+  action "is_installed" {
+    inline = ["for p in \"$@\"; do xbps-query -p pkgver \"$p\"; done; true"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+_\\d+)$"
+    }
+  }
+  action "outdated" {
+    inline = ["xbps-install -un"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<latest>[^-\\s]+_\\d+) update \\S+ (?P<repository>\\S+)"
+    }
+  }
+  action "search" {
+    inline = ["xbps-query -Rs \"$@\""]
+    output {
+      regex = "^\\[[-*]\\] (?P<name>\\S+)-(?P<version>[^-\\s]+_\\d+) +(?P<description>.*)$"
+    }
+  }
+  action "info" {
+    inline = ["for p in \"$@\"; do xbps-query -R -p pkgver,repository,short_desc \"$p\"; done"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>[^-\\s]+_\\d+)\\n(?P<repository>\\S+)\\n(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["cat /etc/xbps.d/*.conf 2>/dev/null | sed -n 's/^repository=//p'"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "add_repo" {
+    inline = ["echo 'repository=${repo.url}' > '/etc/xbps.d/${repo.name}.conf'"]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/xbps.d/${repo.name}.conf'"]
+  }
+}
+
+custom_manager "emerge" {
+  cmd = "emerge"
+  detect = command_exists("emerge") && fileexists("/etc/gentoo-release")
+  flags = ["--ask=n", "--quiet"]
+  action "clean" {
+    flags = ["--depclean"]
+  }
+  action "install" {
+    flags = ["--noreplace"]
+  }
+  action "remove" {
+    flags = ["--depclean"]
+  }
+  action "refresh" {
+    flags = ["--sync"]
+  }
+  action "update" {
+    flags = ["--update", "--deep", "--newuse", "@world"]
+  }
+  // Packages are listed as category/name, so sets should use the same form.
+  action "list_installed" {
+    inline = ["cd /var/db/pkg && ls -d */*"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>\\d\\S*)$"
+    }
+  }
+  action "list_explicit" {
+    // the world file holds the explicitly installed atoms, possibly with a
+    // slot.
+    inline = ["cat /var/lib/portage/world"]
+    output {
+      regex = "^(?P<name>[^:\\s]+)"
+    }
+  }
+  action "is_installed" {
+    inline = ["cd /var/db/pkg && for p in \"$@\"; do ls -d \"$p\"-[0-9]* 2>/dev/null; done; true"]
+    output {
+      regex = "^(?P<name>\\S+)-(?P<version>\\d\\S*)$"
+    }
+  }
+  action "outdated" {
+    flags = ["--pretend", "--update", "--deep", "--newuse", "@world"]
+    output {
+      regex = "^\\[ebuild[^\\]]*U[^\\]]*\\] (?P<name>\\S+)-(?P<latest>\\d\\S*?)(?:::(?P<repository>\\S+))? \\[(?P<version>[^\\]:]+)"
+    }
+  }
+  action "search" {
+    // --quiet, a flag of every other action, leaves out all but the names.
+    inline = ["emerge --search \"$@\""]
+    output {
+      regex = "^\\*  (?P<name>\\S+)(?: \\[ Masked \\])?\\n\\s+Latest version available: (?P<latest>\\S+)\\n\\s+Latest version installed: (?:\\[ Not Installed \\]|(?P<version>\\S+))\\n(?:.*\\n)*?\\s+Description:\\s+(?P<description>.*)$"
+    }
+  }
+  action "info" {
+    inline = [<<-EOT
+      for p in "$@"; do
+        cpv=$(portageq best_visible / "$p") || exit
+        name=$${cpv%-[0-9]*}
+        printf '%s\n%s\n' "$name" "$${cpv#"$name"-}"
+        portageq metadata / ebuild "$cpv" repository DESCRIPTION || exit
+      done
+    EOT
+    ]
+    output {
+      regex = "^(?P<name>\\S+)\\n(?P<version>\\d\\S*)\\n(?P<repository>\\S+)\\n(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["portageq get_repos / | tr ' ' '\\n'"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "add_repo" {
+    inline = ["printf '[%s]\\nlocation = /var/db/repos/%s\\nsync-type = %s\\nsync-uri = %s\\n' '${repo.name}' '${repo.name}' '${repo.type == "" ? "git" : repo.type}' '${repo.url}' > '/etc/portage/repos.conf/${repo.name}.conf'"]
+  }
+  action "remove_repo" {
+    inline = ["rm -f '/etc/portage/repos.conf/${repo.name}.conf'"]
+  }
+}
+
+// Packages are attribute paths such as nixpkgs.ripgrep. Channels are the
+// repositories.
+custom_manager "nix-env" {
+  cmd = "nix-env"
+  detect = sysinfo.os.vendor == "nixos"
+  action "clean" {
+    inline = ["nix-collect-garbage -d"]
+  }
+  action "install" {
+    flags = ["-iA"]
+  }
+  action "remove" {
+    flags = ["-e"]
+  }
+  action "refresh" {
+    inline = ["nix-channel --update"]
+  }
+  action "update" {
+    flags = ["-u"]
+  }
+  action "list_installed" {
+    flags = ["-q", "--attr-path", "--out-path", "--no-name"]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+/nix/store/[0-9a-z]+-\\S*?-(?P<version>\\d\\S*)$"
+    }
+  }
+  action "list_explicit" {
+    flags = ["-q", "--attr-path", "--out-path", "--no-name"]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+/nix/store/[0-9a-z]+-\\S*?-(?P<version>\\d\\S*)$"
+    }
+  }
+  action "is_installed" {
+    inline = ["nix-env -q --attr-path --out-path --no-name | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' \"$@\""]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+/nix/store/[0-9a-z]+-\\S*?-(?P<version>\\d\\S*)$"
+    }
+  }
+  action "outdated" {
+    flags = ["-q", "--attr-path", "--compare-versions"]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+\\S+?-(?P<version>\\d\\S*)[ \\t]+<[ \\t]+(?P<latest>\\S+)"
+    }
+  }
+  action "search" {
+    flags = ["-qaP", "--description"]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+\\S+?-(?P<version>\\d\\S*)[ \\t]+(?P<description>.*)$"
+    }
+  }
+  action "info" {
+    flags = ["-qaP", "--description", "-A"]
+    output {
+      regex = "^(?P<name>\\S+)[ \\t]+\\S+?-(?P<version>\\d\\S*)[ \\t]+(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    inline = ["nix-channel --list"]
+    output {
+      columns = ["name"]
+    }
+  }
+  action "add_repo" {
+    inline = ["nix-channel --add '${repo.url}' '${repo.name}'"]
+  }
+  action "remove_repo" {
+    inline = ["nix-channel --remove '${repo.name}'"]
+  }
+}
+
+// Packages are flake references such as nixpkgs#ripgrep, installed with nix
+// profile. The query actions name installed packages by the reference they
+// were installed from, without the flake: prefix of registry entries and the
+// packages.<system> or legacyPackages.<system> prefix of the attribute, so
+// they match the packages of sets. Flake registry entries are the
+// repositories.
+custom_manager "nix" {
+  cmd = "nix"
+  flags = ["--extra-experimental-features", "nix-command flakes"]
+  action "clean" {
+    flags = ["store", "gc"]
+  }
+  action "install" {
+    flags = ["profile", "install"]
+  }
+  // nix profile remove takes the names of profile elements, the last
+  // attribute of the reference they were installed from.
+  action "remove" {
+    inline = [<<-EOT
+      for p in "$@"; do
+        attr=$${p##*#}
+        shift
+        set -- "$@" "$${attr##*.}"
+      done
+      nix --extra-experimental-features 'nix-command flakes' profile remove "$@"
+    EOT
+    ]
+  }
+  action "refresh" {
+    flags = ["flake", "metadata", "--refresh", "nixpkgs"]
+  }
+  action "update" {
+    flags = ["profile", "upgrade", "--all"]
+  }
+  action "list_installed" {
+    inline = [<<-EOT
+      profile=$(nix --extra-experimental-features 'nix-command flakes' profile list) || exit
+      printf '%s\n' "$profile" | awk '
+        /^Flake attribute:/ { attr = $3; sub(/^(legacyPackages|packages)\.[^.]+\./, "", attr) }
+        /^Original flake URL:/ { url = $4; sub(/^flake:/, "", url) }
+        /^Store paths:/ {
+          path = $3
+          sub(/^\/nix\/store\/[0-9a-z]+-/, "", path)
+          print url "#" attr, (match(path, /-[0-9]/) ? substr(path, RSTART + 1) : "")
+        }
+      '
+    EOT
+    ]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    inline = [<<-EOT
+      profile=$(nix --extra-experimental-features 'nix-command flakes' profile list) || exit
+      printf '%s\n' "$profile" | awk '
+        /^Flake attribute:/ { attr = $3; sub(/^(legacyPackages|packages)\.[^.]+\./, "", attr) }
+        /^Original flake URL:/ { url = $4; sub(/^flake:/, "", url) }
+        /^Store paths:/ {
+          path = $3
+          sub(/^\/nix\/store\/[0-9a-z]+-/, "", path)
+          print url "#" attr, (match(path, /-[0-9]/) ? substr(path, RSTART + 1) : "")
+        }
+      '
+    EOT
+    ]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "is_installed" {
+    inline = [<<-EOT
+      profile=$(nix --extra-experimental-features 'nix-command flakes' profile list) || exit
+      printf '%s\n' "$profile" | awk '
+        /^Flake attribute:/ { attr = $3; sub(/^(legacyPackages|packages)\.[^.]+\./, "", attr) }
+        /^Original flake URL:/ { url = $4; sub(/^flake:/, "", url) }
+        /^Store paths:/ {
+          path = $3
+          sub(/^\/nix\/store\/[0-9a-z]+-/, "", path)
+          print url "#" attr, (match(path, /-[0-9]/) ? substr(path, RSTART + 1) : "")
+        }
+      ' | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' "$@"
+    EOT
+    ]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "outdated" {
+    inline = [<<-EOT
+      profile=$(nix --extra-experimental-features 'nix-command flakes' profile list) || exit
+      printf '%s\n' "$profile" | awk '
+        /^Flake attribute:/ { attr = $3; sub(/^(legacyPackages|packages)\.[^.]+\./, "", attr) }
+        /^Original flake URL:/ { url = $4; sub(/^flake:/, "", url) }
+        /^Store paths:/ {
+          path = $3
+          sub(/^\/nix\/store\/[0-9a-z]+-/, "", path)
+          if (match(path, /-[0-9]/)) print url, attr, substr(path, RSTART + 1)
+        }
+      ' | while read -r url attr version; do
+        latest=$(nix --extra-experimental-features 'nix-command flakes' eval --raw "$url#$attr.version") || exit
+        [ "$latest" = "$version" ] || echo "$url#$attr $version $latest"
+      done
+    EOT
+    ]
+    output {
+      columns = ["name", "version", "latest"]
+    }
+  }
+  action "search" {
+    inline = ["nix --extra-experimental-features 'nix-command flakes' search nixpkgs \"$@\""]
+    output {
+      regex = "^\\* \\S+?\\.(?P<name>[^.\\s]+) \\((?P<version>[^)]*)\\)\\n\\s*(?P<description>.*)$"
+    }
+  }
+  action "info" {
+    inline = ["for p in \"$@\"; do nix --extra-experimental-features 'nix-command flakes' search nixpkgs \"^$p$\"; done"]
+    output {
+      regex = "^\\* \\S+?\\.(?P<name>[^.\\s]+) \\((?P<version>[^)]*)\\)\\n\\s*(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    flags = ["registry", "list"]
+    output {
+      regex = "^user +flake:(?P<name>\\S+) +(?P<repository>\\S+)$"
+    }
+  }
+  action "add_repo" {
+    flags = ["registry", "add", repo.name, repo.url]
+  }
+  action "remove_repo" {
+    flags = ["registry", "remove", repo.name]
+  }
+}
+
+// The language package managers below install into the home of the user
+// invoking omega-pkg. Packages may be pinned as name@version.
+custom_manager "pipx" {
+  cmd = "pipx"
+  as_user = true
+  path = ["~/.local/bin"]
+  // The pip cache is shared by all venvs, so the pip of the shared venv
+  // purges it.
+  action "clean" {
+    inline = ["\"$(pipx environment --value PIPX_SHARED_LIBS)/bin/python\" -m pip cache purge"]
+  }
+  action "install" {
+    flags = ["install"]
+    package = version == "" ? pkg : "${pkg}==${version}"
+  }
+  action "remove" {
+    inline = ["set -e; for p in \"$@\"; do pipx uninstall \"$p\"; done"]
+  }
+  action "update" {
+    flags = ["upgrade-all"]
+  }
+  action "list_installed" {
+    flags = ["list", "--short"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "list_explicit" {
+    flags = ["list", "--short"]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+  action "is_installed" {
+    inline = ["pipx list --short | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' \"$@\""]
+    output {
+      columns = ["name", "version"]
+    }
+  }
+}
+
+custom_manager "npm" {
+  cmd = "npm"
+  flags = ["--global", "--prefix", "~/.local"]
+  as_user = true
+  path = ["~/.local/bin"]
+  action "clean" {
+    flags = ["cache", "clean", "--force"]
+  }
+  action "install" {
+    flags = ["install"]
+    package = version == "" ? pkg : "${pkg}@${version}"
+  }
+  action "remove" {
+    flags = ["uninstall"]
+  }
+  action "update" {
+    flags = ["update"]
+  }
+  action "list_installed" {
+    flags = ["ls", "--depth=0"]
+    output {
+      regex = "^(?:├──|└──|\\+--|`--) (?P<name>@?[^@\\s]+)@(?P<version>\\S+)"
+    }
+  }
+  action "list_explicit" {
+    flags = ["ls", "--depth=0"]
+    output {
+      regex = "^(?:├──|└──|\\+--|`--) (?P<name>@?[^@\\s]+)@(?P<version>\\S+)"
+    }
+  }
+  action "is_installed" {
+    flags = ["ls", "--depth=0"]
+    output {
+      regex = "^(?:├──|└──|\\+--|`--) (?P<name>@?[^@\\s]+)@(?P<version>\\S+)"
+      exit_codes = [1]
+    }
+  }
+  action "outdated" {
+    flags = ["outdated", "--parseable"]
+    output {
+      regex = "^[^:]*:[^:]*:(?P<name>@?[^@:]+)@(?P<version>[^:]+):[^:]*@(?P<latest>[^:]+)"
+      exit_codes = [1]
+    }
+  }
+  action "search" {
+    flags = ["search", "--parseable"]
+    output {
+      columns = ["name", "description", "_", "_", "version"]
+      separator = "\t"
+    }
+  }
+  action "info" {
+    inline = ["for p in \"$@\"; do npm view \"$p\" name version description; done"]
+    output {
+      regex = "^name = '(?P<name>[^']*)'\\nversion = '(?P<version>[^']*)'\\ndescription = '(?P<description>.*)'$"
+    }
+  }
+}
+
+// cargo has no clean action, it cannot clear its registry cache without
+// third-party tools such as cargo-cache.
+custom_manager "cargo" {
+  cmd = "cargo"
+  as_user = true
+  path = ["~/.cargo/bin"]
+  action "install" {
+    flags = ["install", "--locked"]
+    package = version == "" ? pkg : "${pkg}@${version}"
+  }
+  action "remove" {
+    flags = ["uninstall"]
+  }
+  // cargo install only rebuilds a crate if a newer version is available.
+  action "update" {
+    inline = ["cargo install --list | sed -n 's/^\\([^ ]*\\) v.*:$/\\1/p' | xargs -r cargo install --locked"]
+  }
+  action "list_installed" {
+    flags = ["install", "--list"]
+    output {
+      regex = "^(?P<name>\\S+) v(?P<version>[^\\s:]+)(?: \\([^)]*\\))?:$"
+    }
+  }
+  action "list_explicit" {
+    flags = ["install", "--list"]
+    output {
+      regex = "^(?P<name>\\S+) v(?P<version>[^\\s:]+)(?: \\([^)]*\\))?:$"
+    }
+  }
+  action "is_installed" {
+    inline = ["cargo install --list | awk 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' \"$@\""]
+    output {
+      regex = "^(?P<name>\\S+) v(?P<version>[^\\s:]+)(?: \\([^)]*\\))?:$"
+    }
+  }
+  action "search" {
+    flags = ["search"]
+    output {
+      regex = "^(?P<name>\\S+) = \"(?P<version>[^\"]+)\"\\s*(?:# (?P<description>.*))?$"
+    }
+  }
+  action "info" {
+    inline = ["for p in \"$@\"; do cargo search --limit 1 \"$p\"; done"]
+    output {
+      regex = "^(?P<name>\\S+) = \"(?P<version>[^\"]+)\"\\s*(?:# (?P<description>.*))?$"
+    }
+  }
+}
+
+// Packages are the import paths of commands, e.g. golang.org/x/tools/gopls.
+custom_manager "go" {
+  cmd = "go"
+  as_user = true
+  path = ["~/go/bin"]
+  action "clean" {
+    flags = ["clean", "-cache"]
+  }
+  action "install" {
+    flags = ["install"]
+    package = "${pkg}@${version == "" ? "latest" : version}"
+  }
+  // go has no uninstall; the binary is named after the last element of the
+  // import path.
+  action "remove" {
+    inline = ["cd \"$(go env GOPATH)/bin\" && for p in \"$@\"; do rm -f \"$(basename \"$p\")\"; done"]
+  }
+  action "update" {
+    inline = ["go version -m \"$(go env GOPATH)\"/bin/* 2>/dev/null | awk '$1 == \"path\" { print $2 \"@latest\" }' | xargs -r -n 1 go install"]
+  }
+  action "list_installed" {
+    inline = ["go version -m \"$(go env GOPATH)\"/bin/* 2>/dev/null || true"]
+    output {
+      regex = "^\\tpath\\t(?P<name>\\S+)\\n\\tmod\\t\\S+\\t(?P<version>\\S+)"
+    }
+  }
+  action "list_explicit" {
+    inline = ["go version -m \"$(go env GOPATH)\"/bin/* 2>/dev/null || true"]
+    output {
+      regex = "^\\tpath\\t(?P<name>\\S+)\\n\\tmod\\t\\S+\\t(?P<version>\\S+)"
+    }
+  }
+  action "is_installed" {
+    inline = ["cd \"$(go env GOPATH)/bin\" 2>/dev/null && for p in \"$@\"; do go version -m \"$(basename \"$p\")\" 2>/dev/null; done; true"]
+    output {
+      regex = "^\\tpath\\t(?P<name>\\S+)\\n\\tmod\\t\\S+\\t(?P<version>\\S+)"
+    }
+  }
+}
+
+custom_manager "gem" {
+  cmd = "gem"
+  as_user = true
+  path = ["~/.local/bin"]
+  action "clean" {
+    flags = ["cleanup"]
+  }
+  action "install" {
+    flags = ["install", "--user-install", "--no-document", "--bindir", "~/.local/bin"]
+    package = version == "" ? pkg : "${pkg}:${version}"
+  }
+  action "remove" {
+    flags = ["uninstall", "--all", "--executables", "--user-install", "--bindir", "~/.local/bin"]
+  }
+  action "refresh" {
+    flags = ["sources", "--update"]
+  }
+  action "update" {
+    flags = ["update", "--user-install", "--no-document", "--bindir", "~/.local/bin"]
+  }
+  action "list_installed" {
+    flags = ["list", "--local"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?:default: )?(?P<version>[^,)\\s]+)"
+    }
+  }
+  action "list_explicit" {
+    flags = ["list", "--local"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?:default: )?(?P<version>[^,)\\s]+)"
+    }
+  }
+  action "is_installed" {
+    flags = ["list", "--local", "--exact"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?:default: )?(?P<version>[^,)\\s]+)"
+    }
+  }
+  action "outdated" {
+    flags = ["outdated"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?P<version>\\S+) < (?P<latest>[^)]+)\\)$"
+    }
+  }
+  action "search" {
+    flags = ["search", "--remote"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?P<version>[^,)\\s]+)"
+    }
+  }
+  action "info" {
+    flags = ["info", "--remote", "--exact"]
+    output {
+      regex = "^(?P<name>\\S+) \\((?P<version>[^,)\\s]+)[^)]*\\)\\n(?:.*\\S.*\\n)*\\n\\s+(?P<description>\\S.*)$"
+    }
+  }
+  action "list_repos" {
+    flags = ["sources", "--list"]
+    output {
+      regex = "^(?P<name>https?://\\S+)$"
+    }
+  }
+  action "add_repo" {
+    flags = ["sources", "--add", repo.url]
+  }
+  action "remove_repo" {
+    flags = ["sources", "--remove", repo.url]
+  }
+}
+
+// Sets and repositories act on the system installation unless their scope is
+// "user", which runs as the invoking user. Installed apps and remotes are
+// listed per installation. Repositories are remotes; a url to a .flatpakrepo
+// file carries the key of the remote.
+custom_manager "flatpak" {
+  cmd = "flatpak"
+  scopes = ["system", "user"]
+  user_scope = "user"
+  action "clean" {
+    flags = ["uninstall", "--unused", "--noninteractive"]
+  }
+  action "install" {
+    flags = ["install", "--noninteractive", "--${scope}"]
+  }
+  action "remove" {
+    flags = ["uninstall", "--noninteractive", "--${scope}"]
+  }
+  action "refresh" {
+    flags = ["update", "--appstream"]
+  }
+  action "update" {
+    flags = ["update", "--noninteractive"]
+  }
+  action "list_installed" {
+    flags = ["list", "--${scope}", "--app", "--columns=application,version,origin"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<version>[^\\t]*)\\t(?P<repository>\\S*)$"
+    }
+  }
+  action "list_explicit" {
+    flags = ["list", "--${scope}", "--app", "--columns=application,version,origin"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<version>[^\\t]*)\\t(?P<repository>\\S*)$"
+    }
+  }
+  action "is_installed" {
+    inline = ["flatpak list --${scope} --app --columns=application,version,origin | awk -F '\\t' 'BEGIN { for (i = 1; i < ARGC; i++) want[ARGV[i]] = 1; ARGC = 1 } $1 in want' \"$@\""]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<version>[^\\t]*)\\t(?P<repository>\\S*)$"
+    }
+  }
+  action "outdated" {
+    flags = ["remote-ls", "--${scope}", "--updates", "--app", "--columns=application,version,origin"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<latest>[^\\t]*)\\t(?P<repository>\\S*)$"
+    }
+  }
+  action "search" {
+    flags = ["search", "--columns=application,version,remotes,description"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<version>[^\\t]*)\\t(?P<repository>[^\\t]*)\\t(?P<description>.*)$"
+    }
+  }
+  action "info" {
+    flags = ["search", "--columns=application,version,remotes,description"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<version>[^\\t]*)\\t(?P<repository>[^\\t]*)\\t(?P<description>.*)$"
+    }
+  }
+  action "list_repos" {
+    flags = ["remotes", "--${scope}", "--columns=name,url"]
+    output {
+      regex = "^(?P<name>\\S+)\\t(?P<repository>\\S*)"
+    }
+  }
+  action "add_repo" {
+    flags = ["remote-add", "--if-not-exists", "--${repo.scope}", repo.name, repo.url]
+  }
+  action "remove_repo" {
+    flags = ["remote-delete", "--force", "--${repo.scope}", repo.name]
+  }
+}
+
+// Packages are urls or paths of AppImages. They are installed into
+// ~/Applications with a desktop entry taken from the AppImage, which records
+// the package as X-AppImage-Source.
+custom_manager "appimage" {
+  as_user = true
+  env = {
+    APPIMAGE_DIR = "Applications"
+  }
+  action "clean" {
+    inline = [<<-EOT
+      entries="$${XDG_DATA_HOME:-$HOME/.local/share}/applications"
+      for entry in "$entries"/appimage-*.desktop; do
+        [ -e "$entry" ] || continue
+        file=$(sed -n 's/^X-AppImage-File=//p' "$entry")
+        [ -e "$file" ] || rm -f "$entry"
+      done
+    EOT
+    ]
+  }
+  action "install" {
+    cmd = "/bin/sh"
+    flags = ["-c", <<-EOT
+      set -e
+      apps="$HOME/$APPIMAGE_DIR"
+      data="$${XDG_DATA_HOME:-$HOME/.local/share}"
+      mkdir -p "$apps" "$data/applications" "$data/icons"
+      for src in "$@"; do
+        file="$apps/$(basename "$src")"
+        case "$src" in
+          http://*|https://*) curl -fsSL -o "$file.part" "$src" && mv "$file.part" "$file" ;;
+          *) cp "$src" "$file" ;;
+        esac
+        chmod +x "$file"
+        name=$(basename "$file" .AppImage)
+        entry="$data/applications/appimage-$name.desktop"
+        tmp=$(mktemp -d)
+        (cd "$tmp" && "$file" --appimage-extract '*.desktop' && "$file" --appimage-extract .DirIcon) >/dev/null 2>&1 || true
+        desktop=$(ls "$tmp"/squashfs-root/*.desktop 2>/dev/null | head -n 1)
+        if [ -n "$desktop" ]; then
+          sed -e "s|^Exec=[^ ]*|Exec=$file|" -e "s|^TryExec=.*|TryExec=$file|" "$desktop" > "$entry"
+        else
+          printf '[Desktop Entry]\nType=Application\nName=%s\nExec=%s\n' "$name" "$file" > "$entry"
+        fi
+        if [ -e "$tmp/squashfs-root/.DirIcon" ]; then
+          cp -L "$tmp/squashfs-root/.DirIcon" "$data/icons/appimage-$name.png"
+          sed -i "s|^Icon=.*|Icon=$data/icons/appimage-$name.png|" "$entry"
+        fi
+        sed -i "/^\[Desktop Entry\]/a X-AppImage-Source=$src\nX-AppImage-File=$file" "$entry"
+        rm -rf "$tmp"
+      done
+    EOT
+    , "appimage"]
+  }
+  action "remove" {
+    cmd = "/bin/sh"
+    flags = ["-c", <<-EOT
+      data="$${XDG_DATA_HOME:-$HOME/.local/share}"
+      for src in "$@"; do
+        for entry in "$data"/applications/appimage-*.desktop; do
+          grep -qxF "X-AppImage-Source=$src" "$entry" 2>/dev/null || continue
+          name=$(basename "$entry" .desktop)
+          rm -f "$(sed -n 's/^X-AppImage-File=//p' "$entry")" "$data/icons/$name.png" "$entry"
+        done
+      done
+    EOT
+    , "appimage"]
+  }
+  // Only AppImages downloaded from a url are updated, if the server reports a
+  // newer file.
+  action "update" {
+    inline = [<<-EOT
+      for entry in "$${XDG_DATA_HOME:-$HOME/.local/share}"/applications/appimage-*.desktop; do
+        [ -e "$entry" ] || continue
+        src=$(sed -n 's/^X-AppImage-Source=//p' "$entry")
+        file=$(sed -n 's/^X-AppImage-File=//p' "$entry")
+        case "$src" in
+          http://*|https://*)
+            rm -f "$file.part"
+            curl -fsSL -z "$file" -o "$file.part" "$src"
+            if [ -s "$file.part" ]; then mv "$file.part" "$file" && chmod +x "$file"; else rm -f "$file.part"; fi
+            ;;
+        esac
+      done
+    EOT
+    ]
+  }
+  action "list_installed" {
+    inline = ["cat \"$${XDG_DATA_HOME:-$HOME/.local/share}\"/applications/appimage-*.desktop 2>/dev/null || true"]
+    output {
+      regex = "^X-AppImage-Source=(?P<name>.*)$"
+    }
+  }
+  action "list_explicit" {
+    inline = ["cat \"$${XDG_DATA_HOME:-$HOME/.local/share}\"/applications/appimage-*.desktop 2>/dev/null || true"]
+    output {
+      regex = "^X-AppImage-Source=(?P<name>.*)$"
+    }
+  }
+  action "is_installed" {
+    cmd = "/bin/sh"
+    flags = ["-c", "for src in \"$@\"; do cat \"$${XDG_DATA_HOME:-$HOME/.local/share}\"/applications/appimage-*.desktop 2>/dev/null | grep -xF \"X-AppImage-Source=$src\"; done; true", "appimage"]
+    output {
+      regex = "^X-AppImage-Source=(?P<name>.*)$"
+    }
+  }
+}
@@ -0,0 +1,181 @@
+package managers_test
+
+import (
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"omega-pkg/internal/managers"
+	"omega-pkg/pkg/lang"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// baseManagers decodes the custom managers of base.hcl.
+func baseManagers(t *testing.T) map[string]*lang.CustomManager {
+	t.Helper()
+	file, diags := hclparse.NewParser().ParseHCL(managers.Base, "base.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("parse base.hcl: %s", diags)
+	}
+	ctx, err := lang.BuildGlobalContext()
+	if err != nil {
+		t.Fatalf("build global context: %s", err)
+	}
+	var c lang.Config
+	if diags := gohcl.DecodeBody(file.Body, ctx, &c); diags.HasErrors() {
+		t.Fatalf("decode base.hcl: %s", diags)
+	}
+	customManagers := make(map[string]*lang.CustomManager, len(c.CustomManagers))
+	for _, m := range c.CustomManagers {
+		if diags := m.Validate(ctx); diags.HasErrors() {
+			t.Fatalf("validate custom_manager %s: %s", m.Name, diags)
+		}
+		customManagers[m.Name] = m
+	}
+	return customManagers
+}
+
+// TestOutputParsers parses output captured from the package managers, stored
+// in testdata/<manager>/<action>.txt, with the output blocks of base.hcl.
+func TestOutputParsers(t *testing.T) {
+	customManagers := baseManagers(t)
+	tests := []struct {
+		manager string
+		action  string
+		want    []lang.PackageInfo
+	}{
+		{"dnf", lang.ActionOutdated, []lang.PackageInfo{
+			{Name: "bash", Latest: "5.2.26-4.fc40", Repository: "updates"},
+			{Name: "python3.12", Latest: "3.12.4-1.fc40", Repository: "updates"},
+			{Name: "grub2-tools", Latest: "1:2.06-121.fc40", Repository: "updates"},
+		}},
+		{"dnf", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "ripgrep", Description: "Line oriented search tool using Rust's regex library"},
+			{Name: "rust-ripgrep-devel", Description: "Line oriented search tool using Rust's regex library"},
+		}},
+		{"dnf", lang.ActionInfo, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0", Repository: "@System", Description: "Line oriented search tool using Rust's regex library"},
+		}},
+		{"zypper", lang.ActionOutdated, []lang.PackageInfo{
+			{Name: "bash", Version: "5.2.26-2.1", Latest: "5.2.32-1.1", Repository: "openSUSE-Tumbleweed-Oss"},
+			{Name: "curl", Version: "8.8.0-1.1", Latest: "8.9.1-1.1", Repository: "openSUSE-Tumbleweed-Oss"},
+		}},
+		{"zypper", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "ripgrep", Description: "A search tool that combines ag with grep"},
+			{Name: "ripgrep-bash-completion", Description: "Bash Completion for ripgrep"},
+		}},
+		{"zypper", lang.ActionInfo, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0-1.2", Repository: "openSUSE-Tumbleweed-Oss", Description: "A search tool that combines the usability of ag with the raw speed of grep"},
+		}},
+		{"zypper", lang.ActionListExplicit, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0-1.2", Repository: "openSUSE-Tumbleweed-Oss"},
+			{Name: "mytool", Version: "1.0-1", Repository: "@System"},
+		}},
+		{"zypper", lang.ActionListRepos, []lang.PackageInfo{
+			{Name: "repo-debug"},
+			{Name: "repo-oss"},
+		}},
+		{"xbps", lang.ActionListInstalled, []lang.PackageInfo{
+			{Name: "bash", Version: "5.2.026_1"},
+			{Name: "ca-certificates", Version: "20240203+3.98_1"},
+		}},
+		{"xbps", lang.ActionListExplicit, []lang.PackageInfo{
+			{Name: "bash", Version: "5.2.026_1"},
+			{Name: "ca-certificates", Version: "20240203+3.98_1"},
+		}},
+		{"xbps", lang.ActionOutdated, []lang.PackageInfo{
+			{Name: "bash", Latest: "5.2.032_1", Repository: "https://repo-default.voidlinux.org/current"},
+		}},
+		{"xbps", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0_1", Description: "Fast search tool inspired by ag and grep"},
+			{Name: "ripgrep-all", Version: "0.10.6_1", Description: "Ripgrep, but also search in PDFs, E-Books, Office documents"},
+		}},
+		{"xbps", lang.ActionInfo, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0_1", Repository: "https://repo-default.voidlinux.org/current", Description: "Fast search tool inspired by ag and grep"},
+		}},
+		{"emerge", lang.ActionListInstalled, []lang.PackageInfo{
+			{Name: "app-shells/bash", Version: "5.2_p26"},
+			{Name: "media-libs/libsdl2", Version: "2.30.2"},
+			{Name: "sys-apps/portage", Version: "3.0.63-r1"},
+		}},
+		{"emerge", lang.ActionListExplicit, []lang.PackageInfo{
+			{Name: "app-editors/vim"},
+			{Name: "dev-lang/python"},
+		}},
+		{"emerge", lang.ActionOutdated, []lang.PackageInfo{
+			{Name: "sys-apps/portage", Version: "3.0.63-r1", Latest: "3.0.65", Repository: "gentoo"},
+			{Name: "app-misc/bar", Version: "2.1", Latest: "2.0"},
+		}},
+		{"emerge", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "sys-apps/ripgrep", Latest: "14.1.0", Description: "a search tool that combines the usability of ag with the raw speed of grep"},
+			{Name: "sys-apps/ripgrep-all", Version: "0.9.6", Latest: "0.10.6", Description: "rga: ripgrep, but also search in PDFs, E-Books, Office documents, zip, tar.gz, etc."},
+		}},
+		{"emerge", lang.ActionInfo, []lang.PackageInfo{
+			{Name: "sys-apps/ripgrep", Version: "14.1.0", Repository: "gentoo", Description: "a search tool that combines the usability of ag with the raw speed of grep"},
+			{Name: "sys-apps/portage", Version: "3.0.63-r1", Repository: "gentoo", Description: "The package management and distribution system for Gentoo"},
+		}},
+		{"nix-env", lang.ActionListInstalled, []lang.PackageInfo{
+			{Name: "nixpkgs.ripgrep", Version: "14.1.0"},
+			{Name: "nixpkgs.python3", Version: "3.11.9"},
+		}},
+		{"nix-env", lang.ActionOutdated, []lang.PackageInfo{
+			{Name: "nixpkgs.ripgrep", Version: "13.0.0", Latest: "14.1.0"},
+		}},
+		{"nix-env", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "nixpkgs.ripgrep", Version: "14.1.0", Description: "Utility that combines the usability of The Silver Searcher with the raw speed of grep"},
+			{Name: "nixpkgs.ripgrep-all", Version: "0.10.6", Description: "Ripgrep, but also search in PDFs, E-Books, Office documents, zip, tar.gz, and more"},
+		}},
+		{"nix", lang.ActionSearch, []lang.PackageInfo{
+			{Name: "ripgrep", Version: "14.1.0", Description: "Utility that combines the usability of The Silver Searcher with the raw speed of grep"},
+			{Name: "requests", Version: "2.32.3", Description: "HTTP library for Python"},
+		}},
+		{"nix", lang.ActionListRepos, []lang.PackageInfo{
+			{Name: "mypkgs", Repository: "github:example/pkgs"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.manager+"/"+tt.action, func(t *testing.T) {
+			m, ok := customManagers[tt.manager]
+			if !ok {
+				t.Fatalf("custom_manager %s does not exist", tt.manager)
+			}
+			action, ok := m.ActionMap[tt.action]
+			if !ok {
+				t.Fatalf("custom_manager %s has no action %s", tt.manager, tt.action)
+			}
+			out, err := os.ReadFile(filepath.Join("testdata", tt.manager, tt.action+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := action.Output.Parse(string(out))
+			if err != nil {
+				t.Fatalf("parse output: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDistroActions checks that the managers of distributions define every
+// standard and query action.
+func TestDistroActions(t *testing.T) {
+	customManagers := baseManagers(t)
+	actions := append([]string{
+		lang.ActionClean, lang.ActionInstall, lang.ActionRemove, lang.ActionRefresh, lang.ActionUpdate,
+	}, lang.QueryActions...)
+	for _, name := range []string{"pacman", "paru", "apk", "apt", "dnf", "zypper", "xbps", "emerge", "nix-env", "nix"} {
+		m, ok := customManagers[name]
+		if !ok {
+			t.Errorf("custom_manager %s does not exist", name)
+			continue
+		}
+		for _, action := range actions {
+			if _, ok := m.ActionMap[action]; !ok {
+				t.Errorf("custom_manager %s has no action %s", name, action)
+			}
+		}
+	}
+}
@@ -4,17 +4,27 @@ import (
 	"context"
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
 	"omega-pkg/pkg/utils"
+	"os/exec"
 	"strings"
 )
 
 type Action struct {
-	Type    string `hcl:"type,label"`
-	command []string
-	Remain  hcl.Body `hcl:",remain"`
+	Type       string            `hcl:"type,label"`
+	Env        map[string]string `hcl:"env,optional"`
+	WorkingDir string            `hcl:"working_dir,optional"`
+	CleanEnv   *bool             `hcl:"clean_env,optional"`
+	Output     *OutputParser     `hcl:"output,block"`
+	command    []string
+	Remain     hcl.Body `hcl:",remain"`
+
+	ctx     *hcl.EvalContext
+	manager *CustomManager
 }
 
 var ActionRemainSpec = hcldec.ObjectSpec{
@@ -33,49 +43,198 @@ var ActionRemainSpec = hcldec.ObjectSpec{
 		Type:     cty.List(cty.String),
 		Required: false,
 	},
+	"package": &hcldec.AttrSpec{
+		Name:     "package",
+		Type:     cty.String,
+		Required: false,
+	},
 }
 
 func (a *Action) Prepare(
 	ctx *hcl.EvalContext, manager *CustomManager,
 ) (diags hcl.Diagnostics) {
+	a.ctx = ctx
+	a.manager = manager
+	a.command, diags = buildCommand(ctx, manager, a, "", nil, nil, nil)
+	return diags
+}
+
+// SplitPackage splits a package pinned to a version, written as
+// name@version, into its name and version. A leading @, as in npm scopes, is
+// part of the name.
+func SplitPackage(pkg string) (name, version string) {
+	if i := strings.LastIndex(pkg, "@"); i > 0 {
+		return pkg[:i], pkg[i+1:]
+	}
+	return pkg, ""
+}
+
+// packageExpr returns the package attribute of the action, or of its manager
+// if the action has none, or nil if neither formats packages.
+func packageExpr(manager *CustomManager, action *Action) hcl.Expression {
+	schema := &hcl.BodySchema{Attributes: []hcl.AttributeSchema{{Name: "package"}}}
+	for _, body := range []hcl.Body{action.Remain, manager.Remain} {
+		content, _, _ := body.PartialContent(schema)
+		if attr, ok := content.Attributes["package"]; ok {
+			return attr.Expr
+		}
+	}
+	return nil
+}
+
+// formatPackages evaluates the package attribute of the action for every
+// package, with pkg and version set to the name and pinned version.
+func formatPackages(
+	ctx *hcl.EvalContext, manager *CustomManager, action *Action, packages []string,
+) ([]string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	expr := packageExpr(manager, action)
+	if expr == nil {
+		return packages, diags
+	}
+	formatted := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		name, version := SplitPackage(pkg)
+		pkgCtx := ctx.NewChild()
+		pkgCtx.Variables = map[string]cty.Value{
+			"pkg":     cty.StringVal(name),
+			"version": cty.StringVal(version),
+		}
+		var value string
+		moreDiags := gohcl.DecodeExpression(expr, pkgCtx, &value)
+		diags = append(diags, moreDiags...)
+		formatted = append(formatted, value)
+	}
+	return formatted, diags
+}
+
+// buildCommand assembles the command line of an action of manager. The
+// packages, formatted by the package attribute, are available to the flag
+// expressions as pkgs and are appended to the command unless the flags
+// already reference them. scope is available as scope and defaults to the
+// first scope of manager. extra holds the flags of the caller, such as the
+// flags of a set, and may be nil; pkgFlags are the flags of the packages of
+// a set and follow them.
+func buildCommand(
+	ctx *hcl.EvalContext, manager *CustomManager, action *Action, scope string, extra hcl.Body, pkgFlags, packages []string,
+) (command []string, diags hcl.Diagnostics) {
+	ctx = ctx.NewChild()
+	if scope == "" {
+		scope = manager.defaultScope()
+	}
+	if packages == nil {
+		packages = []string{}
+	}
+	packages, diags = formatPackages(ctx, manager, action, packages)
+	pkgs, err := gocty.ToCtyValue(packages, cty.List(cty.String))
+	if err != nil {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "error converting list of packages to cty value",
+		}
+		diags = append(diags, diag)
+	}
+	ctx.Variables = map[string]cty.Value{
+		"pkgs":    pkgs,
+		"pkg":     cty.UnknownVal(cty.String),
+		"version": cty.UnknownVal(cty.String),
+		"scope":   cty.StringVal(scope),
+	}
+
 	managerRemain, moreDiags := hcldec.Decode(manager.Remain, CustomManagerRemainSpec, ctx)
 	diags = append(diags, moreDiags...)
 	globalCmd := utils.ValueToString(managerRemain.GetAttr("cmd"))
 	managerFlags := utils.MapValueToString(managerRemain.GetAttr("flags"))
 
-	actionRemain, moreDiags := hcldec.Decode(a.Remain, ActionRemainSpec, ctx)
+	actionRemain, moreDiags := hcldec.Decode(action.Remain, ActionRemainSpec, ctx)
 	diags = append(diags, moreDiags...)
 	actionCmd := utils.ValueToString(actionRemain.GetAttr("cmd"))
 	actionFlags := utils.MapValueToString(actionRemain.GetAttr("flags"))
 	actionInline := utils.MapValueToString(actionRemain.GetAttr("inline"))
 
+	var extraFlags []string
+	if extra != nil {
+		extraRemain, moreDiags := hcldec.Decode(extra, ActionRemainSpec, ctx)
+		diags = append(diags, moreDiags...)
+		extraFlags = utils.MapValueToString(extraRemain.GetAttr("flags"))
+	}
+
 	var flags []string
 	if len(actionInline) == 0 {
-		flags = append(managerFlags, actionFlags...)
+		flags = append(append(append(managerFlags, actionFlags...), extraFlags...), pkgFlags...)
 	}
 	if len(actionInline) > 0 {
+		script := strings.Join(actionInline, "\n")
 		if actionCmd == "" {
+			// The manager name is $0, so the packages appended below are
+			// the positional parameters of the script.
 			actionCmd = "/bin/sh"
-			flags = []string{"-c"}
+			flags = []string{"-c", script, manager.Name}
+		} else {
+			flags = []string{script}
 		}
-		flags = append(actionFlags, append(flags, strings.Join(actionInline, "\n"))...)
-	} else if actionCmd == "" && globalCmd != "" {
-		actionCmd = globalCmd
-	} else {
-		diag := &hcl.Diagnostic{
-			Severity: hcl.DiagWarning,
-			Summary:  fmt.Sprintf("no global command and no command or inline defined on action %s", a.Type),
+		flags = append(actionFlags, flags...)
+	} else if actionCmd == "" {
+		if globalCmd == "" {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  fmt.Sprintf("no global command and no command or inline defined on action %s", action.Type),
+			}
+			diags = append(diags, diag)
 		}
-		diags = append(diags, diag)
+		actionCmd = globalCmd
 	}
-	a.command = append([]string{actionCmd}, flags...)
-	return diags
+	command = append([]string{actionCmd}, flags...)
 
+	referenced := referencesPackages(manager.Remain, CustomManagerRemainSpec) ||
+		referencesPackages(action.Remain, ActionRemainSpec) ||
+		extra != nil && referencesPackages(extra, ActionRemainSpec)
+	if !referenced {
+		command = append(command, packages...)
+	}
+	return command, diags
+}
+
+// referencesPackages reports whether any expression of body uses pkgs.
+func referencesPackages(body hcl.Body, spec hcldec.Spec) bool {
+	for _, traversal := range hcldec.Variables(body, spec) {
+		if traversal.RootName() == "pkgs" {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *Action) Run(ctx context.Context) error {
+	ctx = context.WithValue(a.environment(ctx).WithContext(ctx), ActionContextKey, a)
 	if _, err := runCommand(ctx, a.command[0], a.command[1:]...); err != nil {
 		return errors.Wrapf(err, "run command on action %s", a.Type)
 	}
 	return nil
 }
+
+// Query runs the action as a read-only command in scope for the packages and
+// parses its output with the output block of the action.
+func (a *Action) Query(ctx context.Context, scope string, packages ...string) ([]PackageInfo, error) {
+	if a.ctx == nil {
+		return nil, errors.Errorf("action %s is not prepared", a.Type)
+	}
+	command, diags := buildCommand(a.ctx, a.manager, a, scope, nil, nil, packages)
+	if diags.HasErrors() {
+		return nil, errors.Wrapf(diags, "build command of action %s", a.Type)
+	}
+	ctx = actionEnvironment(ctx, a.manager, a, scope).WithContext(ctx)
+	out, err := queryCommand(ctx, command[0], command[1:]...)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && a.Output.AllowsExitCode(exitErr.ExitCode()) {
+		err = nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "query action %s", a.Type)
+	}
+	infos, err := a.Output.Parse(out)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse output of action %s", a.Type)
+	}
+	return infos, nil
+}
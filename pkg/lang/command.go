@@ -2,24 +2,41 @@ package lang
 
 import (
 	"context"
+	"github.com/hashicorp/hcl/v2"
 	"github.com/pkg/errors"
 	"strings"
 )
 
 type Command struct {
-	Cmd    string   `hcl:"cmd,optional"`
-	Flags  []string `hcl:"flags,optional"`
-	Inline []string `hcl:"inline"`
+	Cmd         string            `hcl:"cmd,optional"`
+	Flags       []string          `hcl:"flags,optional"`
+	Inline      []string          `hcl:"inline"`
+	Env         map[string]string `hcl:"env,optional"`
+	WorkingDir  string            `hcl:"working_dir,optional"`
+	CleanEnv    *bool             `hcl:"clean_env,optional"`
+	Constraints *Constraints      `hcl:"constraints,block"`
+	Body        hcl.Body          `hcl:",body"`
 }
 
-func (c *Command) Run(ctx context.Context) error {
-	if c.Cmd == "" {
-		c.Cmd = "/bin/sh"
+// Argv returns the command line the command runs.
+func (c *Command) Argv() []string {
+	cmd := c.Cmd
+	if cmd == "" {
+		cmd = "/bin/sh"
 	}
-	c.Flags = append(c.Flags, "-c")
-	flags := append(c.Flags, strings.Join(c.Inline, "\n"))
+	argv := append([]string{cmd}, c.Flags...)
+	return append(argv, "-c", strings.Join(c.Inline, "\n"))
+}
 
-	if _, err := runCommand(ctx, c.Cmd, flags...); err != nil {
+// Environment returns the environment in ctx overridden by the command.
+func (c *Command) Environment(ctx context.Context) Environment {
+	return EnvironmentFromContext(ctx).Merge(c.Env, c.WorkingDir, c.CleanEnv)
+}
+
+func (c *Command) Run(ctx context.Context) error {
+	ctx = c.Environment(ctx).WithContext(ctx)
+	argv := c.Argv()
+	if _, err := runCommand(ctx, argv[0], argv[1:]...); err != nil {
 		return errors.Wrap(err, "run command")
 	}
 	return nil
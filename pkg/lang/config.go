@@ -5,55 +5,129 @@ import (
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 )
 
 type Config struct {
-	Managers         []ManagerOperation `hcl:"manager,block"`
-	CustomManagers   []*CustomManager   `hcl:"custom_manager,block"`
-	Commands         []*Command         `hcl:"command,block"`
+	Managers         []ManagerOperation       `hcl:"manager,block"`
+	CustomManagers   []*CustomManager         `hcl:"custom_manager,block"`
+	Commands         []*Command               `hcl:"command,block"`
+	Overrides        []*CustomManagerOverride `hcl:"override,block"`
+	Packages         []*PackageMapping        `hcl:"package,block"`
 	CustomManagerMap map[string]*CustomManager
 	Remain           hcl.Body `hcl:",remain"`
+	// Sensitive hides the values of sensitive variables in printed commands.
+	Sensitive Redactor
+	// Modules are the modules loaded by the module blocks of the config.
+	Modules []*Module
+
+	evalCtx *hcl.EvalContext
 }
 
 func (c *Config) Validate(ctx *hcl.EvalContext) hcl.Diagnostics {
 	var diags hcl.Diagnostics
+	c.evalCtx = ctx
 	if c.CustomManagerMap == nil {
 		c.CustomManagerMap = make(map[string]*CustomManager)
 	}
 
+	diags = append(diags, c.resolveCustomManagers(ctx)...)
+	diags = append(diags, c.resolveSystemManager(ctx)...)
+	mappings, moreDiags := c.packageMappings(ctx)
+	diags = append(diags, moreDiags...)
 	for _, manager := range c.CustomManagers {
 		moreDiags := manager.Validate(ctx.NewChild())
 		diags = append(diags, moreDiags...)
-		c.CustomManagerMap[manager.Name] = manager
 	}
 	for _, manager := range c.Managers {
 		customManager, ok := c.CustomManagerMap[manager.Name]
-		if !ok {
+		if !ok && manager.Name == SystemManagerName {
+			// reported by resolveSystemManager
+			continue
+		} else if !ok {
 			diag := &hcl.Diagnostic{
 				Severity: hcl.DiagError,
 				Summary:  fmt.Sprintf("manager %s does not exist", manager.Name),
 				Detail:   fmt.Sprintf("manager declared for %s but is no known CustomManager", manager.Name),
 			}
 			diags = append(diags, diag)
+			continue
+		}
+		var moreDiags hcl.Diagnostics
+		for _, required := range []struct {
+			enabled bool
+			name    string
+		}{{manager.Update, ActionUpdate}, {manager.Cleanup, ActionClean}} {
+			if !required.enabled {
+				continue
+			}
+			if _, ok := customManager.ActionMap[required.name]; !ok {
+				subject := manager.Body.MissingItemRange()
+				content, _, _ := manager.Body.PartialContent(&hcl.BodySchema{
+					Attributes: []hcl.AttributeSchema{{Name: required.name}},
+				})
+				if attr, ok := content.Attributes[required.name]; ok {
+					subject = attr.Range
+				}
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("manager %s has no action %s", manager.Name, required.name),
+					Detail:   fmt.Sprintf("Remove %s = true or add an action %q to custom_manager %s.", required.name, required.name, customManager.Name),
+					Subject:  subject.Ptr(),
+				}
+				diags = append(diags, diag)
+				continue
+			}
+			moreDiags = customManager.PrepareAction(ctx, required.name)
+			diags = append(diags, moreDiags...)
+		}
+
+		if _, ok := customManager.ActionMap[ActionRefresh]; ok {
+			moreDiags = customManager.PrepareAction(ctx, ActionRefresh)
+			diags = append(diags, moreDiags...)
+		}
+
+		for _, name := range QueryActions {
+			if _, ok := customManager.ActionMap[name]; ok {
+				moreDiags = customManager.PrepareAction(ctx, name)
+				diags = append(diags, moreDiags...)
+			}
 		}
-		moreDiags := customManager.PrepareAction(ctx, "update")
-		diags = append(diags, moreDiags...)
 
-		moreDiags = customManager.PrepareAction(ctx, "clean")
+		if len(manager.Repositories) > 0 {
+			moreDiags = customManager.PrepareRepositoryActions(ctx)
+			diags = append(diags, moreDiags...)
+		}
+
+		moreDiags = manager.PrepareScopes(customManager)
 		diags = append(diags, moreDiags...)
 
-		moreDiags = customManager.PrepareAction(ctx, "refresh")
+		moreDiags = manager.PrepareSets(ctx, customManager, mappings)
 		diags = append(diags, moreDiags...)
 
-		moreDiags = manager.PrepareSets(ctx, customManager)
+		moreDiags = manager.PrepareExclusive(ctx, customManager)
 		diags = append(diags, moreDiags...)
 
 	}
+	for _, module := range c.Modules {
+		moreDiags := module.Validate(c.CustomManagerMap)
+		diags = append(diags, moreDiags...)
+	}
+	c.declarePackages()
 
 	return diags
 }
 
+// WithEvalContext returns a copy of ctx carrying the EvalContext the config
+// was validated with, which is used to evaluate constraints at run time, and
+// the redactor for sensitive values.
+func (c *Config) WithEvalContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, SensitiveContextKey, c.Sensitive)
+	return context.WithValue(ctx, EvalContextKey, c.evalCtx)
+}
+
 func (c *Config) Run(ctx context.Context) error {
+	ctx = c.WithEvalContext(ctx)
 	for _, manager := range c.Managers {
 		customManager := c.CustomManagerMap[manager.Name]
 		ctx = context.WithValue(ctx, CustomManagerContextKey, customManager)
@@ -62,9 +136,22 @@ func (c *Config) Run(ctx context.Context) error {
 		}
 	}
 	for _, command := range c.Commands {
+		reason, diags := command.Constraints.Evaluate(ctx)
+		if diags.HasErrors() {
+			return errors.Wrap(diags, "evaluate constraints of command")
+		}
+		if reason != "" {
+			zerolog.Ctx(ctx).Info().Str("reason", reason).Msg("skipped command")
+			continue
+		}
 		if err := command.Run(ctx); err != nil {
 			return errors.Wrapf(err, "run command")
 		}
 	}
+	for _, module := range c.Modules {
+		if err := module.Config.Run(ctx); err != nil {
+			return errors.Wrapf(err, "run module %s", module.Name)
+		}
+	}
 	return nil
 }
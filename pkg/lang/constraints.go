@@ -1,25 +1,54 @@
 package lang
 
 import (
-	"github.com/hashicorp/hcl/v2/hcldec"
+	"context"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
+// Constraints decide at run time whether the block they are declared in
+// applies; every attribute is a condition that must be true.
 type Constraints struct {
-	Value bool `hcl:"value"`
+	Remain hcl.Body `hcl:",remain"`
 }
 
-var ConstraintSpec = hcldec.ObjectSpec{
-	"value": &hcldec.AttrSpec{
-		Name:     "value",
-		Type:     cty.Bool,
-		Required: true,
-	},
-}
-
-func (c *Constraints) Match() bool {
+// Evaluate checks the conditions in the order they are declared and stops at
+// the first one that is false. It returns an empty reason if all conditions
+// are true, otherwise a description of the failed condition. The EvalContext
+// is taken from EvalContextKey.
+func (c *Constraints) Evaluate(ctx context.Context) (reason string, diags hcl.Diagnostics) {
 	if c == nil {
-		return true
+		return "", diags
+	}
+	evalCtx, _ := ctx.Value(EvalContextKey).(*hcl.EvalContext)
+
+	attrs, diags := c.Remain.JustAttributes()
+	if diags.HasErrors() {
+		return "", diags
+	}
+	for _, attr := range sortedAttributes(attrs) {
+		val, moreDiags := attr.Expr.Value(evalCtx)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			return "", diags
+		}
+		val, err := convert.Convert(val, cty.Bool)
+		if err != nil || val.IsNull() || !val.IsKnown() {
+			diag := &hcl.Diagnostic{
+				Severity:    hcl.DiagError,
+				Summary:     "invalid constraint",
+				Detail:      fmt.Sprintf("constraint %s must be a known bool value", attr.Name),
+				Subject:     attr.Expr.Range().Ptr(),
+				Expression:  attr.Expr,
+				EvalContext: evalCtx,
+			}
+			return "", append(diags, diag)
+		}
+		if val.False() {
+			return fmt.Sprintf("constraint %s is false (%s)", attr.Name, formatRange(attr.Range)), diags
+		}
 	}
-	return c.Value
+	return "", diags
 }
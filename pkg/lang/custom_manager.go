@@ -1,22 +1,41 @@
 package lang
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 )
 
+// CustomManager is a custom_manager block describing how to drive a package
+// manager through its actions, see examples/custom_managers.hcl.
 type CustomManager struct {
-	Name string `hcl:"name,label"`
+	Name     string `hcl:"name,label"`
+	Extends  string `hcl:"extends,optional"`
+	Disabled bool   `hcl:"disabled,optional"`
+
+	Detect        hcl.Expression `hcl:"detect,optional"`
+	VersionFormat hcl.Expression `hcl:"version_format,optional"`
+
+	Env        map[string]string `hcl:"env,optional"`
+	WorkingDir string            `hcl:"working_dir,optional"`
+	CleanEnv   *bool             `hcl:"clean_env,optional"`
+	AsUser     bool              `hcl:"as_user,optional"`
+	Path       []string          `hcl:"path,optional"`
+	Scopes     []string          `hcl:"scopes,optional"`
+	UserScope  string            `hcl:"user_scope,optional"`
 
 	Actions   []*Action `hcl:"action,block"`
 	ActionMap map[string]*Action
 	Remain    hcl.Body `hcl:",remain"`
+	Body      hcl.Body `hcl:",body"`
 }
 type CustomManagerRemain struct {
-	CmdExpr   hcl.Expression `hcl:"cmd,optional"`
-	FlagExprs hcl.Expression `hcl:"flags,optional"`
+	CmdExpr     hcl.Expression `hcl:"cmd,optional"`
+	FlagExprs   hcl.Expression `hcl:"flags,optional"`
+	PackageExpr hcl.Expression `hcl:"package,optional"`
 }
 
 var CustomManagerRemainSpec = hcldec.ObjectSpec{
@@ -30,6 +49,11 @@ var CustomManagerRemainSpec = hcldec.ObjectSpec{
 		Type:     cty.List(cty.String),
 		Required: false,
 	},
+	"package": &hcldec.AttrSpec{
+		Name:     "package",
+		Type:     cty.String,
+		Required: false,
+	},
 }
 
 func (m *CustomManager) Validate(ctx *hcl.EvalContext) hcl.Diagnostics {
@@ -48,12 +72,37 @@ func (m *CustomManager) Validate(ctx *hcl.EvalContext) hcl.Diagnostics {
 		for _, action := range m.Actions {
 			//moreDiags := action.Validate(ctx.NewChild(), m.CmdExpr, m.FlagExprs)
 			//diags = append(diags, moreDiags...)
+			moreDiags := action.Output.Validate()
+			diags = append(diags, moreDiags...)
 			m.ActionMap[action.Type] = action
 		}
 	}
 	return diags
 }
 
+// clone returns a copy of the manager with copies of its actions, so
+// preparing the actions of the copy leaves those of m alone.
+func (m *CustomManager) clone() *CustomManager {
+	clone := *m
+	copies := make(map[*Action]*Action, len(m.Actions))
+	clone.Actions = make([]*Action, 0, len(m.Actions))
+	for _, action := range m.Actions {
+		copied := *action
+		copies[action] = &copied
+		clone.Actions = append(clone.Actions, &copied)
+	}
+	clone.ActionMap = make(map[string]*Action, len(m.ActionMap))
+	for name, action := range m.ActionMap {
+		if copied, ok := copies[action]; ok {
+			clone.ActionMap[name] = copied
+		} else {
+			copied := *action
+			clone.ActionMap[name] = &copied
+		}
+	}
+	return &clone
+}
+
 func (m *CustomManager) PrepareAction(ctx *hcl.EvalContext, name string) hcl.Diagnostics {
 	var diags hcl.Diagnostics
 	action, ok := m.ActionMap[name]
@@ -69,3 +118,73 @@ func (m *CustomManager) PrepareAction(ctx *hcl.EvalContext, name string) hcl.Dia
 	}
 	return diags
 }
+
+// PrepareRepositoryActions prepares the actions used by repo blocks. Only
+// add_repo is required, import_key and remove_repo are checked when used.
+func (m *CustomManager) PrepareRepositoryActions(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, name := range RepositoryActions {
+		action, ok := m.ActionMap[name]
+		if !ok {
+			if name == ActionAddRepo {
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("manager %s declares repositories but has no action %s", m.Name, name),
+				}
+				diags = append(diags, diag)
+			}
+			continue
+		}
+		// repo is only known per repository, so the command is built when planning.
+		action.ctx = ctx
+		action.manager = m
+	}
+	return diags
+}
+
+// Query runs the query action name in scope for the packages.
+func (m *CustomManager) Query(ctx context.Context, name, scope string, packages ...string) ([]PackageInfo, error) {
+	action, ok := m.ActionMap[name]
+	if !ok {
+		return nil, errors.Errorf("action %s does not exist on manager %s", name, m.Name)
+	}
+	return action.Query(ctx, scope, packages...)
+}
+
+// scopes returns the scopes of the manager, or the single scope "" if it has
+// none.
+func (m *CustomManager) scopes() []string {
+	if len(m.Scopes) == 0 {
+		return []string{""}
+	}
+	return m.Scopes
+}
+
+// defaultScope returns the first scope of the manager, or "".
+func (m *CustomManager) defaultScope() string {
+	return m.scopes()[0]
+}
+
+// InstalledPackages holds the installed packages of a custom_manager by scope
+// and name. Managers without scopes have the single scope "".
+type InstalledPackages map[string]map[string]PackageInfo
+
+// Installed queries all installed packages using the list_installed action,
+// in every scope of the manager.
+func (m *CustomManager) Installed(ctx context.Context) (InstalledPackages, error) {
+	installed := make(InstalledPackages)
+	for _, scope := range m.scopes() {
+		infos, err := m.Query(ctx, ActionListInstalled, scope)
+		if err != nil {
+			if scope != "" {
+				return nil, errors.Wrapf(err, "list installed packages of scope %s", scope)
+			}
+			return nil, errors.Wrap(err, "list installed packages")
+		}
+		installed[scope] = make(map[string]PackageInfo, len(infos))
+		for _, info := range infos {
+			installed[scope][info.Name] = info
+		}
+	}
+	return installed, nil
+}
@@ -0,0 +1,66 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+)
+
+// SystemManagerName is the name of the manager resolving to the custom_manager
+// detected as the package manager of the host.
+const SystemManagerName = "system"
+
+// Detects evaluates the detect expression of the manager. Managers without
+// one are never detected.
+func (m *CustomManager) Detects(ctx *hcl.EvalContext) (bool, hcl.Diagnostics) {
+	var detected bool
+	if !isSet(m.Detect) {
+		return false, nil
+	}
+	diags := gohcl.DecodeExpression(m.Detect, ctx, &detected)
+	return detected && !diags.HasErrors(), diags
+}
+
+// DetectSystemManager returns the first custom manager, in order of
+// declaration, whose detect expression is true, or nil if none is.
+func (c *Config) DetectSystemManager(ctx *hcl.EvalContext) (*CustomManager, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	for _, manager := range c.CustomManagers {
+		detected, moreDiags := manager.Detects(ctx)
+		diags = append(diags, moreDiags...)
+		if detected {
+			return manager, diags
+		}
+	}
+	return nil, diags
+}
+
+// resolveSystemManager maps the system manager to the detected custom manager
+// unless a custom_manager of that name exists. It is an error if a manager
+// block uses it and no custom manager is detected.
+func (c *Config) resolveSystemManager(ctx *hcl.EvalContext) hcl.Diagnostics {
+	if _, ok := c.CustomManagerMap[SystemManagerName]; ok {
+		return nil
+	}
+	detected, diags := c.DetectSystemManager(ctx)
+	if detected != nil {
+		c.CustomManagerMap[SystemManagerName] = detected
+		return diags
+	}
+	for _, manager := range c.Managers {
+		if manager.Name != SystemManagerName {
+			continue
+		}
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "no system package manager detected",
+			Detail: fmt.Sprintf(
+				"The detect expression of no custom_manager matched this host, so manager %q cannot be resolved. Name the manager explicitly or add a detect expression to a custom_manager.",
+				SystemManagerName,
+			),
+			Subject: manager.Body.MissingItemRange().Ptr(),
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
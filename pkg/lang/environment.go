@@ -0,0 +1,113 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog"
+	"path/filepath"
+	"sort"
+)
+
+// Environment is the process environment commands run in, built from the
+// env, working_dir and clean_env attributes of the enclosing blocks.
+type Environment struct {
+	Env        []string `json:"env,omitempty"`
+	WorkingDir string   `json:"working_dir,omitempty"`
+	CleanEnv   *bool    `json:"clean_env,omitempty"`
+	User       string   `json:"user,omitempty"`
+}
+
+// EnvironmentFromContext returns the environment stored in ctx by
+// Environment.WithContext.
+func EnvironmentFromContext(ctx context.Context) Environment {
+	var e Environment
+	e.Env, _ = ctx.Value(EnvContextKey).([]string)
+	e.WorkingDir, _ = ctx.Value(CwdContextKey).(string)
+	e.CleanEnv, _ = ctx.Value(CleanEnvContextKey).(*bool)
+	e.User, _ = ctx.Value(UserContextKey).(string)
+	return e
+}
+
+// WithContext returns a copy of ctx carrying the environment, replacing the
+// one already in ctx.
+func (e Environment) WithContext(ctx context.Context) context.Context {
+	ctx = context.WithValue(ctx, EnvContextKey, e.Env)
+	ctx = context.WithValue(ctx, CwdContextKey, e.WorkingDir)
+	ctx = context.WithValue(ctx, UserContextKey, e.User)
+	return context.WithValue(ctx, CleanEnvContextKey, e.CleanEnv)
+}
+
+// Merge returns e overridden by the attributes of a block. A relative
+// workingDir is resolved against the working directory of e.
+func (e Environment) Merge(env map[string]string, workingDir string, cleanEnv *bool) Environment {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+	return e.Override(Environment{Env: pairs, WorkingDir: workingDir, CleanEnv: cleanEnv})
+}
+
+// Override returns e overridden by o.
+func (e Environment) Override(o Environment) Environment {
+	merged := Environment{
+		Env:        append(append([]string{}, e.Env...), o.Env...),
+		WorkingDir: e.WorkingDir,
+		CleanEnv:   e.CleanEnv,
+		User:       e.User,
+	}
+	if o.User != "" {
+		merged.User = o.User
+	}
+	if o.WorkingDir != "" {
+		if filepath.IsAbs(o.WorkingDir) || e.WorkingDir == "" {
+			merged.WorkingDir = o.WorkingDir
+		} else {
+			merged.WorkingDir = filepath.Join(e.WorkingDir, o.WorkingDir)
+		}
+	}
+	if o.CleanEnv != nil {
+		merged.CleanEnv = o.CleanEnv
+	}
+	if len(merged.Env) == 0 {
+		merged.Env = nil
+	}
+	return merged
+}
+
+// environment returns the environment of the action: the one of its manager
+// overridden by the action, overridden in turn by the environment in ctx,
+// which holds the one of the manager and set blocks using the action.
+func (a *Action) environment(ctx context.Context) Environment {
+	return actionEnvironment(ctx, a.manager, a, "")
+}
+
+// actionEnvironment returns the environment of action of manager, which may
+// be nil, in scope. The actions of a manager with as_user, or in its
+// user_scope, run as the invoking user.
+func actionEnvironment(ctx context.Context, manager *CustomManager, action *Action, scope string) Environment {
+	var e Environment
+	if manager != nil {
+		e = e.Merge(manager.Env, manager.WorkingDir, manager.CleanEnv)
+		if manager.AsUser || scope != "" && scope == manager.UserScope {
+			if u, err := InvokingUser(); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Str("manager", manager.Name).
+					Msg("unable to determine the invoking user, running as the current user")
+			} else {
+				e = e.ForUser(u, manager.Path)
+			}
+		}
+	}
+	e = e.Merge(action.Env, action.WorkingDir, action.CleanEnv)
+	return e.Override(EnvironmentFromContext(ctx))
+}
+
+// withEnvironment returns a copy of ctx carrying the environment of the
+// manager block.
+func (m *ManagerOperation) withEnvironment(ctx context.Context) context.Context {
+	return EnvironmentFromContext(ctx).Merge(m.Env, m.WorkingDir, m.CleanEnv).WithContext(ctx)
+}
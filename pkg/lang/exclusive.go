@@ -0,0 +1,166 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"path"
+	"sort"
+)
+
+// Ignore is an ignore block of an exclusive manager block. It keeps the
+// explicitly installed packages matching its glob patterns.
+type Ignore struct {
+	Packages []string `hcl:"packages"`
+	Body     hcl.Body `hcl:",body"`
+}
+
+// Matches reports whether a pattern of the ignore block matches name.
+func (i *Ignore) Matches(name string) bool {
+	for _, pattern := range i.Packages {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PrepareExclusive checks that customManager can list the explicitly
+// installed packages and remove them, and validates the ignore patterns.
+func (m *ManagerOperation) PrepareExclusive(ctx *hcl.EvalContext, customManager *CustomManager) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if !m.Exclusive {
+		for _, ignore := range m.Ignores {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  fmt.Sprintf("ignore block of manager %s has no effect", m.Name),
+				Detail:   "Ignore blocks only apply to manager blocks with exclusive = true.",
+				Subject:  ignore.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+		return diags
+	}
+	for _, name := range []string{ActionListExplicit, ActionRemove} {
+		if _, ok := customManager.ActionMap[name]; !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("manager %s cannot be exclusive", m.Name),
+				Detail:   fmt.Sprintf("custom_manager %s has no action %s.", customManager.Name, name),
+				Subject:  m.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+	diags = append(diags, customManager.PrepareAction(ctx, ActionRemove)...)
+	for _, ignore := range m.Ignores {
+		for _, pattern := range ignore.Packages {
+			if _, err := path.Match(pattern, ""); err != nil {
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("invalid ignore pattern %q", pattern),
+					Detail:   fmt.Sprintf("The pattern is no valid glob pattern: %s.", err),
+					Subject:  ignore.Body.MissingItemRange().Ptr(),
+				}
+				diags = append(diags, diag)
+			}
+		}
+	}
+	return diags
+}
+
+// declarePackages records for every exclusive manager block the packages of
+// all sets, in the config and its modules, for the same custom_manager by
+// scope. The sets count whatever their action and constraints, so exclusive
+// never removes a package a set mentions. Modules have copies of the custom
+// managers, so they are told apart by name.
+func (c *Config) declarePackages() {
+	operations, customManagers := c.operations()
+	declared := make(map[string]map[string]map[string]bool)
+	for i, operation := range operations {
+		if customManagers[i] == nil {
+			continue
+		}
+		name := customManagers[i].Name
+		if declared[name] == nil {
+			declared[name] = make(map[string]map[string]bool)
+		}
+		for _, set := range operation.Sets {
+			if declared[name][set.Scope] == nil {
+				declared[name][set.Scope] = make(map[string]bool)
+			}
+			for _, spec := range set.Packages {
+				declared[name][set.Scope][spec.Name] = true
+			}
+		}
+	}
+	for i, operation := range operations {
+		if operation.Exclusive && customManagers[i] != nil {
+			operation.declared = declared[customManagers[i].Name]
+		}
+	}
+}
+
+// PlanExclusive returns the steps removing the explicitly installed packages
+// no set declares and no ignore block matches, one for every scope of
+// customManager, or nil if the manager block is not exclusive.
+func (m *ManagerOperation) PlanExclusive(ctx context.Context, customManager *CustomManager) ([]*PlanStep, error) {
+	if !m.Exclusive {
+		return nil, nil
+	}
+	var steps []*PlanStep
+	for _, scope := range customManager.scopes() {
+		step, err := m.planExclusive(ctx, customManager, scope)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (m *ManagerOperation) planExclusive(ctx context.Context, customManager *CustomManager, scope string) (*PlanStep, error) {
+	infos, err := customManager.Query(ctx, ActionListExplicit, scope)
+	if err != nil {
+		return nil, errors.Wrap(err, "list explicitly installed packages")
+	}
+	step := &PlanStep{
+		Action:    ActionRemove,
+		Range:     m.Body.MissingItemRange(),
+		Exclusive: true,
+		Scope:     scope,
+	}
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		if m.declared[scope][info.Name] || seen[info.Name] || m.ignores(info.Name) {
+			continue
+		}
+		seen[info.Name] = true
+		step.Packages = append(step.Packages, info.Name)
+	}
+	if len(step.Packages) == 0 {
+		return step, nil
+	}
+	sort.Strings(step.Packages)
+	action := customManager.ActionMap[ActionRemove]
+	command, diags := buildCommand(action.ctx, customManager, action, scope, nil, nil, step.Packages)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "build command of action remove")
+	}
+	step.Command = command
+	step.Environment = actionEnvironment(ctx, customManager, action, scope)
+	return step, nil
+}
+
+func (m *ManagerOperation) ignores(name string) bool {
+	for i := range m.Ignores {
+		if m.Ignores[i].Matches(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package lang
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"omega-pkg/pkg/lang/funcs"
+	"path/filepath"
+)
+
+// fileBody is the body of a config file. Its expressions are evaluated with
+// the filesystem functions resolving relative paths against the directory of
+// the file instead of the working directory.
+type fileBody struct {
+	hcl.Body
+	functions map[string]function.Function
+}
+
+// FileBody wraps the body of the config file in dir.
+func FileBody(body hcl.Body, dir string) hcl.Body {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return &fileBody{Body: body, functions: funcs.FileFunctions(dir, templateFunctions)}
+}
+
+func (b *fileBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	content, diags := b.Body.Content(schema)
+	return b.wrapContent(content), diags
+}
+
+func (b *fileBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	content, remain, diags := b.Body.PartialContent(schema)
+	if remain != nil {
+		remain = &fileBody{Body: remain, functions: b.functions}
+	}
+	return b.wrapContent(content), remain, diags
+}
+
+func (b *fileBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.Body.JustAttributes()
+	return b.wrapAttributes(attrs), diags
+}
+
+func (b *fileBody) wrapContent(content *hcl.BodyContent) *hcl.BodyContent {
+	if content == nil {
+		return nil
+	}
+	wrapped := *content
+	wrapped.Attributes = b.wrapAttributes(content.Attributes)
+	wrapped.Blocks = make(hcl.Blocks, 0, len(content.Blocks))
+	for _, block := range content.Blocks {
+		wrappedBlock := *block
+		wrappedBlock.Body = &fileBody{Body: block.Body, functions: b.functions}
+		wrapped.Blocks = append(wrapped.Blocks, &wrappedBlock)
+	}
+	return &wrapped
+}
+
+func (b *fileBody) wrapAttributes(attrs hcl.Attributes) hcl.Attributes {
+	if attrs == nil {
+		return nil
+	}
+	wrapped := make(hcl.Attributes, len(attrs))
+	for name, attr := range attrs {
+		wrappedAttr := *attr
+		wrappedAttr.Expr = &fileExpr{Expression: attr.Expr, functions: b.functions}
+		wrapped[name] = &wrappedAttr
+	}
+	return wrapped
+}
+
+// fileExpr is an expression of a fileBody.
+type fileExpr struct {
+	hcl.Expression
+	functions map[string]function.Function
+}
+
+func (e *fileExpr) Value(ctx *hcl.EvalContext) (cty.Value, hcl.Diagnostics) {
+	if ctx == nil {
+		return e.Expression.Value(nil)
+	}
+	ctx = ctx.NewChild()
+	ctx.Functions = e.functions
+	return e.Expression.Value(ctx)
+}
+
+// UnwrapExpression lets hcl.ExprList, hcl.ExprAsKeyword and friends see the
+// wrapped expression.
+func (e *fileExpr) UnwrapExpression() hcl.Expression {
+	return e.Expression
+}
+
+// templateFunctions are the functions available to templates rendered by
+// templatefile.
+func templateFunctions() map[string]function.Function {
+	functions := Functions()
+	delete(functions, "templatefile")
+	return functions
+}
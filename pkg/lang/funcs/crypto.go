@@ -0,0 +1,58 @@
+package funcs
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hashicorp/go-uuid"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"hash"
+	"time"
+)
+
+// Sha256Func returns the hex encoded SHA-256 digest of a string.
+var Sha256Func = makeStringHashFunction(sha256.New)
+
+// Md5Func returns the hex encoded MD5 digest of a string.
+var Md5Func = makeStringHashFunction(md5.New)
+
+func makeStringHashFunction(hf func() hash.Hash) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "str",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			h := hf()
+			h.Write([]byte(args[0].AsString()))
+			return cty.StringVal(hex.EncodeToString(h.Sum(nil))), nil
+		},
+	})
+}
+
+// UUIDFunc returns a random version 4 UUID. Every call returns a new one.
+var UUIDFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		result, err := uuid.GenerateUUID()
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "generate uuid")
+		}
+		return cty.StringVal(result), nil
+	},
+})
+
+// TimestampFunc returns the current time in UTC as an RFC 3339 string.
+var TimestampFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(time.Now().UTC().Format(time.RFC3339)), nil
+	},
+})
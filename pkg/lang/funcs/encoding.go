@@ -0,0 +1,45 @@
+package funcs
+
+import (
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"unicode/utf8"
+)
+
+// Base64EncodeFunc encodes a string with standard base64.
+var Base64EncodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "str",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(base64.StdEncoding.EncodeToString([]byte(args[0].AsString()))), nil
+	},
+})
+
+// Base64DecodeFunc decodes a standard base64 string. The result must be
+// valid UTF-8.
+var Base64DecodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "str",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		decoded, err := base64.StdEncoding.DecodeString(args[0].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "decode base64")
+		}
+		if !utf8.Valid(decoded) {
+			return cty.UnknownVal(cty.String), errors.New("decoded base64 is not valid UTF-8")
+		}
+		return cty.StringVal(string(decoded)), nil
+	},
+})
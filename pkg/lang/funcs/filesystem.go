@@ -1 +1,325 @@
 package funcs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/bmatcuk/doublestar"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// FileFunctions returns the filesystem functions with relative paths resolved
+// against baseDir. funcsCb returns the functions available to templates
+// rendered by templatefile; if it is nil templatefile is left out.
+// file_exists is an alias of fileexists.
+func FileFunctions(baseDir string, funcsCb func() map[string]function.Function) map[string]function.Function {
+	functions := map[string]function.Function{
+		"abspath":     MakeAbsPathFunc(baseDir),
+		"basename":    BasenameFunc,
+		"dirname":     DirnameFunc,
+		"file":        MakeFileFunc(baseDir, false),
+		"filebase64":  MakeFileFunc(baseDir, true),
+		"file_exists": MakeFileExistsFunc(baseDir),
+		"fileexists":  MakeFileExistsFunc(baseDir),
+		"fileset":     MakeFileSetFunc(baseDir),
+		"filesha256":  MakeFileSHA256Func(baseDir),
+		"pathexpand":  PathExpandFunc,
+	}
+	if funcsCb != nil {
+		functions["templatefile"] = MakeTemplateFileFunc(baseDir, funcsCb)
+	}
+	return functions
+}
+
+// resolvePath expands a leading ~ and resolves a relative path against
+// baseDir.
+func resolvePath(baseDir, path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", errors.Wrap(err, "expand home directory")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path), nil
+}
+
+func readFile(baseDir, path string) ([]byte, error) {
+	path, err := resolvePath(baseDir, path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file %s", path)
+	}
+	return src, nil
+}
+
+// MakeFileFunc returns a function reading a file. With encBase64 the contents
+// are returned base64 encoded, otherwise they must be valid UTF-8.
+func MakeFileFunc(baseDir string, encBase64 bool) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			src, err := readFile(baseDir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			if encBase64 {
+				return cty.StringVal(base64.StdEncoding.EncodeToString(src)), nil
+			}
+			if !utf8.Valid(src) {
+				return cty.UnknownVal(cty.String), errors.Errorf(
+					"contents of %s are not valid UTF-8; use filebase64 to read binary files", args[0].AsString(),
+				)
+			}
+			return cty.StringVal(string(src)), nil
+		},
+	})
+}
+
+// MakeFileExistsFunc returns a function checking whether a file or directory
+// exists.
+func MakeFileExistsFunc(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.Bool),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path, err := resolvePath(baseDir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.Bool), err
+			}
+			_, err = os.Stat(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return cty.False, nil
+			} else if err != nil {
+				return cty.UnknownVal(cty.Bool), errors.Wrap(err, "stat file")
+			}
+			return cty.True, nil
+		},
+	})
+}
+
+// MakeFileSetFunc returns a function listing the files below a directory
+// matching a pattern, which may contain ** to match any number of
+// directories. The paths are relative to the directory.
+func MakeFileSetFunc(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+			{
+				Name: "pattern",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.Set(cty.String)),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path, err := resolvePath(baseDir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(retType), err
+			}
+			pattern := filepath.Join(path, args[1].AsString())
+			matches, err := doublestar.Glob(pattern)
+			if err != nil {
+				return cty.UnknownVal(retType), errors.Wrapf(err, "match pattern %s", pattern)
+			}
+			var files []cty.Value
+			for _, match := range matches {
+				info, err := os.Stat(match)
+				if err != nil {
+					return cty.UnknownVal(retType), errors.Wrapf(err, "stat file %s", match)
+				}
+				if !info.Mode().IsRegular() {
+					continue
+				}
+				rel, err := filepath.Rel(path, match)
+				if err != nil {
+					return cty.UnknownVal(retType), errors.Wrapf(err, "make path %s relative", match)
+				}
+				files = append(files, cty.StringVal(filepath.ToSlash(rel)))
+			}
+			if len(files) == 0 {
+				return cty.SetValEmpty(cty.String), nil
+			}
+			return cty.SetVal(files), nil
+		},
+	})
+}
+
+// MakeFileSHA256Func returns a function hashing the contents of a file with
+// SHA-256, returning the hex encoded digest.
+func MakeFileSHA256Func(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			src, err := readFile(baseDir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			sum := sha256.Sum256(src)
+			return cty.StringVal(hex.EncodeToString(sum[:])), nil
+		},
+	})
+}
+
+// MakeTemplateFileFunc returns a function rendering a file as an HCL template
+// with the given variables and the functions returned by funcsCb.
+func MakeTemplateFileFunc(baseDir string, funcsCb func() map[string]function.Function) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+			{
+				Name: "vars",
+				Type: cty.DynamicPseudoType,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path, err := resolvePath(baseDir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return cty.UnknownVal(cty.String), errors.Wrapf(err, "read template %s", path)
+			}
+			expr, diags := hclsyntax.ParseTemplate(src, path, hcl.InitialPos)
+			if diags.HasErrors() {
+				return cty.UnknownVal(cty.String), errors.Wrapf(diags, "parse template %s", path)
+			}
+
+			varsVal := args[1]
+			if !varsVal.Type().IsObjectType() && !varsVal.Type().IsMapType() {
+				return cty.UnknownVal(cty.String), errors.New("vars must be an object or map")
+			}
+			vars := make(map[string]cty.Value)
+			if !varsVal.IsNull() {
+				for it := varsVal.ElementIterator(); it.Next(); {
+					k, v := it.Element()
+					vars[k.AsString()] = v
+				}
+			}
+			ctx := &hcl.EvalContext{
+				Variables: vars,
+				Functions: funcsCb(),
+			}
+			// Templates resolve paths relative to their own directory.
+			for name, fn := range FileFunctions(filepath.Dir(path), nil) {
+				ctx.Functions[name] = fn
+			}
+			val, diags := expr.Value(ctx)
+			if diags.HasErrors() {
+				return cty.UnknownVal(cty.String), errors.Wrapf(diags, "render template %s", path)
+			}
+			val, err = convert.Convert(val, cty.String)
+			if err != nil {
+				return cty.UnknownVal(cty.String), errors.Wrapf(err, "render template %s", path)
+			}
+			return val, nil
+		},
+	})
+}
+
+// MakeAbsPathFunc returns a function turning a path into an absolute path,
+// resolving it against baseDir.
+func MakeAbsPathFunc(baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{
+				Name: "path",
+				Type: cty.String,
+			},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := args[0].AsString()
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return cty.UnknownVal(cty.String), errors.Wrap(err, "make path absolute")
+			}
+			return cty.StringVal(filepath.ToSlash(abs)), nil
+		},
+	})
+}
+
+// PathExpandFunc replaces a leading ~ with the home directory of the user.
+var PathExpandFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "path",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		path, err := homedir.Expand(args[0].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "expand home directory")
+		}
+		return cty.StringVal(path), nil
+	},
+})
+
+// DirnameFunc returns all but the last element of a path.
+var DirnameFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "path",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(filepath.Dir(args[0].AsString())), nil
+	},
+})
+
+// BasenameFunc returns the last element of a path.
+var BasenameFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "path",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(filepath.Base(args[0].AsString())), nil
+	},
+})
@@ -0,0 +1,204 @@
+package funcs
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync"
+)
+
+const OsReleasePath = "/etc/os-release"
+
+// OsReleaseIDFunc returns the ID field of /etc/os-release, e.g. arch, debian
+// or alpine.
+var OsReleaseIDFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		data, err := os.ReadFile(OsReleasePath)
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "read os-release")
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "ID=") {
+				return cty.StringVal(strings.Trim(strings.TrimPrefix(line, "ID="), `"'`)), nil
+			}
+		}
+		return cty.StringVal(""), nil
+	},
+})
+
+// ArchFunc returns the machine hardware name as reported by uname, e.g.
+// x86_64 or aarch64.
+var ArchFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		var uname unix.Utsname
+		if err := unix.Uname(&uname); err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "uname")
+		}
+		return cty.StringVal(unix.ByteSliceToString(uname.Machine[:])), nil
+	},
+})
+
+// HostnameFunc returns the host name reported by the kernel.
+var HostnameFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "get hostname")
+		}
+		return cty.StringVal(hostname), nil
+	},
+})
+
+// CommandExistsFunc returns whether an executable of the given name is found
+// in PATH.
+var CommandExistsFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "cmd",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Bool),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		_, err := exec.LookPath(args[0].AsString())
+		return cty.BoolVal(err == nil), nil
+	},
+})
+
+// EnvFunc returns the value of an environment variable, or the optional
+// default if it is not set.
+var EnvFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "name",
+			Type: cty.String,
+		},
+	},
+	VarParam: &function.Parameter{
+		Name: "default",
+		Type: cty.String,
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		if len(args) > 2 {
+			return cty.UnknownVal(cty.String), errors.New("env takes at most one default")
+		}
+		if value, ok := os.LookupEnv(args[0].AsString()); ok {
+			return cty.StringVal(value), nil
+		}
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return cty.StringVal(""), nil
+	},
+})
+
+// UserFunc returns the name of the user running omega-pkg.
+var UserFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		current, err := user.Current()
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "get current user")
+		}
+		return cty.StringVal(current.Username), nil
+	},
+})
+
+// HomeFunc returns the home directory of the user running omega-pkg.
+var HomeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return cty.UnknownVal(cty.String), errors.Wrap(err, "get home directory")
+		}
+		return cty.StringVal(home), nil
+	},
+})
+
+// WhichFunc returns the path of an executable found in PATH, or an empty
+// string if there is none.
+var WhichFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "cmd",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		path, err := exec.LookPath(args[0].AsString())
+		if err != nil {
+			return cty.StringVal(""), nil
+		}
+		return cty.StringVal(path), nil
+	},
+})
+
+// commandOutputs caches the results of command_output by command line, so a
+// command runs at most once per run no matter how often it is evaluated.
+var commandOutputs sync.Map
+
+type commandOutput struct {
+	once sync.Once
+	out  string
+	err  error
+}
+
+// CommandOutputFunc runs a command without a shell and returns its stdout with
+// surrounding whitespace trimmed. It is meant for read-only commands such as
+// uname -r: the command runs while the config is decoded, even in dryrun
+// mode, and its output is cached for the rest of the run.
+var CommandOutputFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "cmd",
+			Type: cty.String,
+		},
+	},
+	VarParam: &function.Parameter{
+		Name: "args",
+		Type: cty.String,
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		argv := make([]string, 0, len(args))
+		for _, arg := range args {
+			argv = append(argv, arg.AsString())
+		}
+		cached, _ := commandOutputs.LoadOrStore(strings.Join(argv, "\x00"), &commandOutput{})
+		result := cached.(*commandOutput)
+		result.once.Do(func() {
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				result.err = errors.Wrapf(err, "run %s: %s", argv[0], strings.TrimSpace(stderr.String()))
+				return
+			}
+			result.out = strings.TrimSpace(stdout.String())
+		})
+		if result.err != nil {
+			return cty.UnknownVal(cty.String), result.err
+		}
+		return cty.StringVal(result.out), nil
+	},
+})
@@ -0,0 +1,347 @@
+package funcs
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SemverCompareFunc compares two versions, returning -1, 0 or 1 if the first
+// is lower than, equal to or greater than the second, by semver precedence;
+// see CompareSemver.
+var SemverCompareFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "a",
+			Type: cty.String,
+		},
+		{
+			Name: "b",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		result, err := CompareSemver(args[0].AsString(), args[1].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Number), err
+		}
+		return cty.NumberIntVal(int64(result)), nil
+	},
+})
+
+// VersionCompareFunc compares two package versions like SemverCompareFunc,
+// but the way dpkg and rpm do, so distro versions such as 1:2.39.2-1.1 or
+// 1.2.3-r0 sort as their package manager sorts them; see CompareVersions.
+var VersionCompareFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "a",
+			Type: cty.String,
+		},
+		{
+			Name: "b",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		result, err := CompareVersions(args[0].AsString(), args[1].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Number), err
+		}
+		return cty.NumberIntVal(int64(result)), nil
+	},
+})
+
+// VersionMatchesFunc reports whether a version satisfies a constraint such as
+// ">= 1.2, < 2.0" or "~> 1.4". The operators are =, !=, >, >=, <, <= and
+// ~>, which allows only the last given component to grow.
+var VersionMatchesFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{
+			Name: "version",
+			Type: cty.String,
+		},
+		{
+			Name: "constraint",
+			Type: cty.String,
+		},
+	},
+	Type: function.StaticReturnType(cty.Bool),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		ok, err := MatchesVersion(args[0].AsString(), args[1].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.Bool), err
+		}
+		return cty.BoolVal(ok), nil
+	},
+})
+
+// packageVersion is a version split into the parts dpkg and rpm compare
+// separately: [epoch:]upstream[-revision].
+type packageVersion struct {
+	epoch    int
+	upstream string
+	revision string
+}
+
+// parseVersion splits s into its epoch, upstream version and revision. A
+// leading v, as in v1.2.0, is ignored.
+func parseVersion(s string) (packageVersion, error) {
+	var v packageVersion
+	unsupported := func(reason string) error {
+		return errors.Errorf("unsupported version format %q: %s", s, reason)
+	}
+	for _, r := range s {
+		if !isVersionRune(r) {
+			return v, unsupported(fmt.Sprintf("invalid character %q", r))
+		}
+	}
+	rest := s
+	if i := strings.Index(rest, ":"); i >= 0 {
+		epoch, err := strconv.Atoi(rest[:i])
+		if err != nil || epoch < 0 {
+			return v, unsupported("the epoch before : must be a number")
+		}
+		v.epoch, rest = epoch, rest[i+1:]
+	}
+	if i := strings.LastIndex(rest, "-"); i >= 0 {
+		rest, v.revision = rest[:i], rest[i+1:]
+	}
+	if len(rest) > 1 && rest[0] == 'v' && isDigit(rest[1]) {
+		rest = rest[1:]
+	}
+	if rest == "" || !isDigit(rest[0]) {
+		return v, unsupported("the version must start with a digit")
+	}
+	v.upstream = rest
+	return v, nil
+}
+
+func isVersionRune(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+		strings.ContainsRune(".+~^_:-", r)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// CompareVersions compares two package versions like dpkg: epochs first, then
+// the upstream versions and the revisions, comparing runs of digits
+// numerically and everything else character by character, with letters
+// sorting before other characters and ~ before anything, even the end of the
+// version. So 1.0~rc1 < 1.0 < 1.0-1 < 1.0a < 1.0.1 < 1:0.9.
+func CompareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.compare(vb), nil
+}
+
+func (v packageVersion) compare(o packageVersion) int {
+	switch {
+	case v.epoch < o.epoch:
+		return -1
+	case v.epoch > o.epoch:
+		return 1
+	}
+	if c := compareParts(v.upstream, o.upstream); c != 0 {
+		return c
+	}
+	return compareParts(v.revision, o.revision)
+}
+
+// compareParts implements the comparison of dpkg's verrevcmp.
+func compareParts(a, b string) int {
+	for a != "" || b != "" {
+		for a != "" && !isDigit(a[0]) || b != "" && !isDigit(b[0]) {
+			ca, cb := charOrder(a), charOrder(b)
+			if ca != cb {
+				return sign(ca - cb)
+			}
+			a, b = a[1:], b[1:]
+		}
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		na, nb := digits(a), digits(b)
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		if c := strings.Compare(a[:na], b[:nb]); c != 0 {
+			return c
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return 0
+}
+
+// charOrder returns the weight of the first character of s for compareParts.
+// The end of s and digits weigh 0.
+func charOrder(s string) int {
+	switch {
+	case s == "" || isDigit(s[0]):
+		return 0
+	case s[0] == '~':
+		return -1
+	case s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z':
+		return int(s[0])
+	default:
+		return int(s[0]) + 256
+	}
+}
+
+func digits(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// semverPattern matches a semantic version, with an optional leading v. The
+// groups are the major, minor and patch version and the pre-release.
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+[0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*)?$`)
+
+// CompareSemver compares two semantic versions by their precedence, so a
+// pre-release such as 1.0.0-rc.1 sorts before 1.0.0 and build metadata is
+// ignored. Versions that are not semantic versions, such as 1:2.39.2-1.1,
+// are compared with CompareVersions.
+func CompareSemver(a, b string) (int, error) {
+	ma, mb := semverPattern.FindStringSubmatch(a), semverPattern.FindStringSubmatch(b)
+	if ma == nil || mb == nil {
+		return CompareVersions(a, b)
+	}
+	for i := 1; i <= 3; i++ {
+		if c := compareNumbers(ma[i], mb[i]); c != 0 {
+			return c, nil
+		}
+	}
+	return comparePrereleases(ma[4], mb[4]), nil
+}
+
+// compareNumbers compares two numbers without leading zeros.
+func compareNumbers(a, b string) int {
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// comparePrereleases compares the pre-releases of two semantic versions,
+// with no pre-release sorting last.
+func comparePrereleases(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	ia, ib := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		na, nb := digits(ia[i]) == len(ia[i]), digits(ib[i]) == len(ib[i])
+		var c int
+		switch {
+		case na && nb:
+			c = compareNumbers(ia[i], ib[i])
+		case na:
+			c = -1
+		case nb:
+			c = 1
+		default:
+			c = strings.Compare(ia[i], ib[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return sign(len(ia) - len(ib))
+}
+
+// MatchesVersion reports whether version satisfies all comma separated
+// conditions of constraint, see VersionMatchesFunc.
+func MatchesVersion(version, constraint string) (bool, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	for _, condition := range strings.Split(constraint, ",") {
+		ok, err := matchesCondition(v, strings.TrimSpace(condition))
+		if err != nil {
+			return false, errors.Wrapf(err, "parse constraint %q", constraint)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func matchesCondition(v packageVersion, condition string) (bool, error) {
+	op := strings.TrimRight(condition[:len(condition)-len(strings.TrimLeft(condition, "=!<>~"))], " ")
+	target, err := parseVersion(strings.TrimSpace(condition[len(op):]))
+	if err != nil {
+		return false, err
+	}
+	c := v.compare(target)
+	switch op {
+	case "", "=", "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "~>":
+		upper, ok := target.pessimisticBound()
+		return c >= 0 && (!ok || v.compare(upper) < 0), nil
+	default:
+		return false, errors.Errorf("unsupported operator %q", op)
+	}
+}
+
+// pessimisticBound returns the version ~> v must stay below: v without its
+// last component, with the component before incremented. A version with a
+// single component has no bound.
+func (v packageVersion) pessimisticBound() (packageVersion, bool) {
+	parts := strings.Split(v.upstream, ".")
+	if len(parts) < 2 {
+		return v, false
+	}
+	parts = parts[:len(parts)-1]
+	last := parts[len(parts)-1]
+	n, _ := strconv.Atoi(last[:digits(last)])
+	parts[len(parts)-1] = strconv.Itoa(n + 1)
+	return packageVersion{epoch: v.epoch, upstream: strings.Join(parts, ".")}, true
+}
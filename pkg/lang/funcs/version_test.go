@@ -0,0 +1,113 @@
+package funcs_test
+
+import (
+	"omega-pkg/pkg/lang/funcs"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.10", "1.9", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0-1", -1},
+		{"1.0-1", "1.0a", -1},
+		{"1.0a", "1.0.1", -1},
+		{"1.0.1", "1:0.9", -1},
+		{"1:2.39.2-1.1", "1:2.39.2-1", 1},
+		{"1.2.3-r0", "1.2.3-r1", -1},
+		{"1.2.3-r0", "1.2.3", 1},
+		{"v1.2.0", "1.2.0", 0},
+		{"5.2.26-4.fc40", "5.2.32-1.fc40", -1},
+	}
+	for _, tt := range tests {
+		got, err := funcs.CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareVersions(%q, %q): %s", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	for _, version := range []string{"", "abc", "1.0 beta", "x:1.0"} {
+		if _, err := funcs.CompareVersions(version, "1.0"); err == nil {
+			t.Errorf("CompareVersions(%q, \"1.0\") succeeded, want an error", version)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.9.0", "1.10.0", -1},
+		{"v2.0.0", "1.99.99", 1},
+		// Not semantic versions, compared like dpkg.
+		{"1:2.39.2-1.1", "2.40.0", 1},
+		{"1.0", "1.0.0", -1},
+	}
+	for _, tt := range tests {
+		got, err := funcs.CompareSemver(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareSemver(%q, %q): %s", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareSemver(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesVersion(t *testing.T) {
+	tests := []struct {
+		version    string
+		constraint string
+		want       bool
+	}{
+		{"1.2.0", "1.2.0", true},
+		{"1.2.0", "= 1.2", false},
+		{"1.2.0", "!= 1.2.1", true},
+		{"1.2.0", ">= 1.2, < 2.0", true},
+		{"2.0", ">= 1.2, < 2.0", false},
+		{"1.1", ">= 1.2, < 2.0", false},
+		{"1.5", "> 1.2, <= 1.5, != 1.4", true},
+		{"1.4", "> 1.2, <= 1.5, != 1.4", false},
+		{"1.4.0", "~> 1.4", true},
+		{"1.9", "~> 1.4", true},
+		{"2.0", "~> 1.4", false},
+		{"1.3", "~> 1.4", false},
+		{"1.4.9", "~> 1.4.2", true},
+		{"1.5.0", "~> 1.4.2", false},
+		{"3.0", "~> 2", true},
+		{"1:2.39.2-1.1", ">= 1:2.39", true},
+	}
+	for _, tt := range tests {
+		got, err := funcs.MatchesVersion(tt.version, tt.constraint)
+		if err != nil {
+			t.Errorf("MatchesVersion(%q, %q): %s", tt.version, tt.constraint, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("MatchesVersion(%q, %q) = %t, want %t", tt.version, tt.constraint, got, tt.want)
+		}
+	}
+	for _, constraint := range []string{"=> 1.0", ">= x", "~> "} {
+		if _, err := funcs.MatchesVersion("1.0", constraint); err == nil {
+			t.Errorf("MatchesVersion(\"1.0\", %q) succeeded, want an error", constraint)
+		}
+	}
+}
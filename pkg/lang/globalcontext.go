@@ -6,32 +6,42 @@ import (
 	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/pkg/errors"
 	"github.com/zcalusic/sysinfo"
+	ctyyaml "github.com/zclconf/go-cty-yaml"
 	"github.com/zclconf/go-cty/cty"
 	"github.com/zclconf/go-cty/cty/function"
 	"github.com/zclconf/go-cty/cty/function/stdlib"
 	"github.com/zclconf/go-cty/cty/gocty"
+	"omega-pkg/pkg/lang/funcs"
 )
 
 func Functions() map[string]function.Function {
 
 	functions := map[string]function.Function{
 		"abs":             stdlib.AbsoluteFunc,
+		"arch":            funcs.ArchFunc,
+		"base64decode":    funcs.Base64DecodeFunc,
+		"base64encode":    funcs.Base64EncodeFunc,
 		"can":             tryfunc.CanFunc,
 		"ceil":            stdlib.CeilFunc,
 		"chomp":           stdlib.ChompFunc,
 		"coalescelist":    stdlib.CoalesceListFunc,
+		"command_exists":  funcs.CommandExistsFunc,
+		"command_output":  funcs.CommandOutputFunc,
 		"compact":         stdlib.CompactFunc,
 		"concat":          stdlib.ConcatFunc,
 		"contains":        stdlib.ContainsFunc,
 		"csvdecode":       stdlib.CSVDecodeFunc,
 		"distinct":        stdlib.DistinctFunc,
 		"element":         stdlib.ElementFunc,
+		"env":             funcs.EnvFunc,
 		"chunklist":       stdlib.ChunklistFunc,
 		"flatten":         stdlib.FlattenFunc,
 		"floor":           stdlib.FloorFunc,
 		"format":          stdlib.FormatFunc,
 		"formatdate":      stdlib.FormatDateFunc,
 		"formatlist":      stdlib.FormatListFunc,
+		"home":            funcs.HomeFunc,
+		"hostname":        funcs.HostnameFunc,
 		"indent":          stdlib.IndentFunc,
 		"index":           stdlib.IndexFunc,
 		"join":            stdlib.JoinFunc,
@@ -43,8 +53,10 @@ func Functions() map[string]function.Function {
 		"lookup":          stdlib.LookupFunc,
 		"lower":           stdlib.LowerFunc,
 		"max":             stdlib.MaxFunc,
+		"md5":             funcs.Md5Func,
 		"merge":           stdlib.MergeFunc,
 		"min":             stdlib.MinFunc,
+		"os_release_id":   funcs.OsReleaseIDFunc,
 		"parseint":        stdlib.ParseIntFunc,
 		"pow":             stdlib.PowFunc,
 		"range":           stdlib.RangeFunc,
@@ -52,10 +64,12 @@ func Functions() map[string]function.Function {
 		"regexall":        stdlib.RegexAllFunc,
 		"replace":         stdlib.ReplaceFunc,
 		"reverse":         stdlib.ReverseListFunc,
+		"semvercompare":   funcs.SemverCompareFunc,
 		"setintersection": stdlib.SetIntersectionFunc,
 		"setproduct":      stdlib.SetProductFunc,
 		"setsubtract":     stdlib.SetSubtractFunc,
 		"setunion":        stdlib.SetUnionFunc,
+		"sha256":          funcs.Sha256Func,
 		"signum":          stdlib.SignumFunc,
 		"slice":           stdlib.SliceFunc,
 		"sort":            stdlib.SortFunc,
@@ -63,6 +77,7 @@ func Functions() map[string]function.Function {
 		"strrev":          stdlib.ReverseFunc,
 		"substr":          stdlib.SubstrFunc,
 		"timeadd":         stdlib.TimeAddFunc,
+		"timestamp":       funcs.TimestampFunc,
 		"title":           stdlib.TitleFunc,
 		"tostring":        stdlib.MakeToFunc(cty.String),
 		"tonumber":        stdlib.MakeToFunc(cty.Number),
@@ -76,10 +91,20 @@ func Functions() map[string]function.Function {
 		"trimsuffix":      stdlib.TrimSuffixFunc,
 		"try":             tryfunc.TryFunc,
 		"upper":           stdlib.UpperFunc,
+		"user":            funcs.UserFunc,
+		"uuid":            funcs.UUIDFunc,
 		"values":          stdlib.ValuesFunc,
+		"version_matches": funcs.VersionMatchesFunc,
+		"versioncompare":  funcs.VersionCompareFunc,
+		"which":           funcs.WhichFunc,
+		"yamldecode":      ctyyaml.YAMLDecodeFunc,
+		"yamlencode":      ctyyaml.YAMLEncodeFunc,
 		"zipmap":          stdlib.ZipmapFunc,
 		"convert":         typeexpr.ConvertFunc,
 	}
+	for name, fn := range funcs.FileFunctions(".", templateFunctions) {
+		functions[name] = fn
+	}
 	return functions
 }
 
@@ -0,0 +1,76 @@
+package lang
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"omega-pkg/pkg/state"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// recordCommand adds the command with the arguments argv, started at start
+// and finished with err, to the run in ctx, if any. The manager block, step
+// or action it belongs to are taken from ctx and sensitive values are
+// redacted.
+func recordCommand(ctx context.Context, argv []string, start time.Time, err error) {
+	run, ok := ctx.Value(RunContextKey).(*state.Run)
+	if !ok {
+		return
+	}
+	command := &state.Command{
+		Action:   "command",
+		ExitCode: exitCode(err),
+		Start:    start,
+		Duration: time.Since(start),
+	}
+	for _, arg := range argv {
+		command.Argv = append(command.Argv, redact(ctx, arg))
+	}
+	command.Manager, _ = ctx.Value(ManagerContextKey).(string)
+	if step, ok := ctx.Value(StepContextKey).(*PlanStep); ok {
+		command.Action = step.Action
+		if step.Action == ActionInstall || step.Action == ActionRemove {
+			command.Packages = redactAll(ctx, step.Packages)
+			if !step.Exclusive {
+				command.Set = formatRange(step.Range)
+			}
+		}
+	} else if action, ok := ctx.Value(ActionContextKey).(*Action); ok {
+		command.Action = action.Type
+	}
+	run.Record(command)
+}
+
+// exitCode returns the exit code of a command that finished with err, -1 if
+// it did not exit normally.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// ConfigHash returns the SHA-256 hash of the parsed files, including the
+// built-in managers and modules, to tell which config a run used.
+func ConfigHash(files map[string]*hcl.File) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write(files[name].Bytes)
+		hash.Write([]byte{0})
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -12,7 +12,10 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type contextKey struct {
@@ -25,31 +28,123 @@ const (
 	ActionRemove  = "remove"
 	ActionRefresh = "refresh"
 	ActionUpdate  = "update"
+	// ActionDowngrade installs packages pinned to an older version, used by
+	// rollback instead of install if the manager has it.
+	ActionDowngrade = "downgrade"
+
+	ActionListInstalled = "list_installed"
+	ActionListExplicit  = "list_explicit"
+	ActionIsInstalled   = "is_installed"
+	ActionOutdated      = "outdated"
+	ActionSearch        = "search"
+	ActionInfo          = "info"
+	ActionListRepos     = "list_repos"
+
+	ActionAddRepo    = "add_repo"
+	ActionRemoveRepo = "remove_repo"
+	ActionImportKey  = "import_key"
 )
 
+// QueryActions are the read-only actions of a CustomManager. Their output is
+// parsed into PackageInfo values instead of being printed.
+var QueryActions = []string{
+	ActionListInstalled, ActionListExplicit, ActionIsInstalled, ActionOutdated, ActionSearch, ActionInfo, ActionListRepos,
+}
+
+// RepositoryActions are the actions used to apply the repo blocks of a manager.
+var RepositoryActions = []string{ActionAddRepo, ActionRemoveRepo, ActionImportKey}
+
+func IsQueryAction(name string) bool {
+	for _, action := range QueryActions {
+		if action == name {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	EnvContextKey           = contextKey{"env"}
 	DryrunContextKey        = contextKey{"dryrun"}
 	CwdContextKey           = contextKey{"cwd"}
 	ActionContextKey        = contextKey{"action"}
 	CustomManagerContextKey = contextKey{"customManager"}
+	EvalContextKey          = contextKey{"evalContext"}
+	SensitiveContextKey     = contextKey{"sensitive"}
+	CleanEnvContextKey      = contextKey{"cleanEnv"}
+	UserContextKey          = contextKey{"user"}
+	ManagerContextKey       = contextKey{"manager"}
+	StepContextKey          = contextKey{"step"}
+	RunContextKey           = contextKey{"run"}
 )
 
-func runCommand(ctx context.Context, command string, args ...string) (string, error) {
+func newCommand(ctx context.Context, command string, args ...string) (*exec.Cmd, error) {
+	var env []string
+	extra, _ := ctx.Value(EnvContextKey).([]string)
+	if clean, _ := ctx.Value(CleanEnvContextKey).(*bool); clean != nil && *clean {
+		env = append([]string{}, extra...)
+	} else if len(extra) > 0 {
+		env = append(os.Environ(), extra...)
+	}
+	path, err := lookPath(command, env)
+	if err != nil {
+		return nil, errors.Wrap(err, "find command")
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Args[0] = command
+	cmd.Env = env
+	if cwd, ok := ctx.Value(CwdContextKey).(string); ok {
+		cmd.Dir = cwd
+	}
+	name, _ := ctx.Value(UserContextKey).(string)
+	cred, err := credential(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "switch user")
+	}
+	if cred != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	return cmd, nil
+}
 
-	cmd := exec.CommandContext(ctx, command, args...)
-	if cwd := ctx.Value(CwdContextKey); cwd != nil {
-		cmd.Dir = cwd.(string)
+// lookPath resolves command like exec.LookPath, but in the PATH of env
+// instead of the one of omega-pkg, so the path of an action, such as the one
+// of a custom_manager run as a user, applies to its command as well. Commands
+// containing a slash and environments without PATH are left to exec.
+func lookPath(command string, env []string) (string, error) {
+	path, ok := "", false
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			path, ok = kv[len("PATH="):], true
+		}
 	}
-	if env := ctx.Value(EnvContextKey); env != nil {
-		cmd.Env = append(os.Environ(), env.([]string)...)
+	if !ok || strings.Contains(command, "/") {
+		return command, nil
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		file := filepath.Join(dir, command)
+		if info, err := os.Stat(file); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return file, nil
+		}
+	}
+	return "", &exec.Error{Name: command, Err: exec.ErrNotFound}
+}
+
+func runCommand(ctx context.Context, command string, args ...string) (string, error) {
+
+	cmd, err := newCommand(ctx, command, args...)
+	if err != nil {
+		return "", err
 	}
 
 	if ctx.Value(DryrunContextKey) == true {
 		for _, s := range cmd.Env {
-			fmt.Println(s)
+			fmt.Println(redact(ctx, s))
 		}
-		fmt.Println(command + " " + strings.Join(args, " "))
+		fmt.Println(redact(ctx, command+" "+strings.Join(args, " ")))
 		return "", nil
 	}
 
@@ -58,11 +153,15 @@ func runCommand(ctx context.Context, command string, args ...string) (string, er
 	cmd.Stdout = io.MultiWriter(os.Stdout, &stdBuffer)
 	cmd.Stderr = os.Stderr
 
+	start := time.Now()
 	if err := cmd.Start(); err != nil {
+		recordCommand(ctx, cmd.Args, start, err)
 		return "", errors.Wrap(err, "start command")
 	}
 
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	recordCommand(ctx, cmd.Args, start, err)
+	if err != nil {
 		return "", errors.Wrap(err, "wait for command completion")
 	}
 
@@ -70,6 +169,24 @@ func runCommand(ctx context.Context, command string, args ...string) (string, er
 
 }
 
+// queryCommand runs a read-only command and returns its stdout. Unlike
+// runCommand it also runs in dryrun mode and does not echo its output.
+func queryCommand(ctx context.Context, command string, args ...string) (string, error) {
+	cmd, err := newCommand(ctx, command, args...)
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), errors.Wrapf(err, "run query %s: %s", command, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
 func PrepareFlags(ctx *hcl.EvalContext, flagExprs []hcl.Expression) (hcl.Diagnostics, [][]string) {
 	var diags hcl.Diagnostics
 	var flags [][]string
@@ -0,0 +1,115 @@
+package lang
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConfigDirName     = "omega-pkg"
+	SystemConfigDir   = "/etc/omega-pkg"
+	HCLFileSuffix     = ".hcl"
+	HCLJSONFileSuffix = ".hcl.json"
+)
+
+// ConfigSearchPaths returns the directories searched for configuration files
+// when no path is given explicitly, in order of preference.
+func ConfigSearchPaths() []string {
+	var paths []string
+	if configDir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(configDir, ConfigDirName))
+	}
+	paths = append(paths, SystemConfigDir)
+	if cwd, err := os.Getwd(); err == nil {
+		paths = append(paths, cwd)
+	}
+	return paths
+}
+
+// FindConfigFiles resolves the configuration files to load. If path is set it
+// may name a single file or a directory, otherwise the first directory of
+// ConfigSearchPaths that contains configuration files is used.
+func FindConfigFiles(path string) ([]string, error) {
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "stat config path")
+		}
+		if !info.IsDir() {
+			return []string{path}, nil
+		}
+		files, err := ConfigFilesInDir(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, errors.Errorf("no config files found in %s", path)
+		}
+		return files, nil
+	}
+
+	searchPaths := ConfigSearchPaths()
+	for _, dir := range searchPaths {
+		files, err := ConfigFilesInDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			return files, nil
+		}
+	}
+	return nil, errors.Errorf("no config files found in %s", strings.Join(searchPaths, ", "))
+}
+
+// ConfigFilesInDir lists all *.hcl and *.hcl.json files in dir but lock files,
+// sorted by name.
+func ConfigFilesInDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read config dir %s", dir)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !IsConfigFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	return files, nil
+}
+
+// IsConfigFile reports whether name is a configuration file. Lock files are
+// not, although they are HCL files.
+func IsConfigFile(name string) bool {
+	if strings.HasSuffix(name, LockFileSuffix) {
+		return false
+	}
+	return strings.HasSuffix(name, HCLFileSuffix) || strings.HasSuffix(name, HCLJSONFileSuffix)
+}
+
+// ParseConfigFiles parses every file with the parser, choosing the JSON or
+// native syntax by file suffix.
+func ParseConfigFiles(parser *hclparse.Parser, paths []string) ([]hcl.Body, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var bodies []hcl.Body
+	for _, path := range paths {
+		var f *hcl.File
+		var moreDiags hcl.Diagnostics
+		if strings.HasSuffix(path, HCLJSONFileSuffix) {
+			f, moreDiags = parser.ParseJSONFile(path)
+		} else {
+			f, moreDiags = parser.ParseHCLFile(path)
+		}
+		diags = append(diags, moreDiags...)
+		if f != nil {
+			bodies = append(bodies, FileBody(f.Body, filepath.Dir(path)))
+		}
+	}
+	return bodies, diags
+}
@@ -1,9 +1,10 @@
 package lang
 
 import (
+	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
-	"github.com/zclconf/go-cty/cty"
+	"sort"
 )
 
 type Local struct {
@@ -14,23 +15,49 @@ type LocalConfig struct {
 	Remain hcl.Body `hcl:",remain"`
 }
 
+// DecodeLocals decodes the locals blocks of body. The attributes are returned
+// in source order and evaluated later by DecodeGlobals, since locals may
+// reference each other and variables.
 func DecodeLocals(body hcl.Body, ctx *hcl.EvalContext) (
-	locals map[string]cty.Value, remain hcl.Body, diags hcl.Diagnostics,
+	locals []*hcl.Attribute, remain hcl.Body, diags hcl.Diagnostics,
 ) {
-	locals = make(map[string]cty.Value)
 	var loc LocalConfig
 	moreDiags := gohcl.DecodeBody(body, ctx, &loc)
 	diags = append(diags, moreDiags...)
+	declared := make(map[string]*hcl.Attribute)
 	for _, local := range loc.Locals {
 		attrs, moreDiags := local.Remain.JustAttributes()
 		diags = append(diags, moreDiags...)
-		for key, attribute := range attrs {
-			val, moreDiags := attribute.Expr.Value(ctx)
-			diags = append(diags, moreDiags...)
-			locals[key] = val
-			ctx.Variables["local"] = cty.ObjectVal(locals)
+		for _, attribute := range sortedAttributes(attrs) {
+			if previous, ok := declared[attribute.Name]; ok {
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("duplicate local %s", attribute.Name),
+					Detail:   fmt.Sprintf("The local %s was already declared at %s.", attribute.Name, previous.NameRange),
+					Subject:  attribute.NameRange.Ptr(),
+				}
+				diags = append(diags, diag)
+				continue
+			}
+			declared[attribute.Name] = attribute
+			locals = append(locals, attribute)
 		}
 	}
 	remain = loc.Remain
 	return
 }
+
+// sortedAttributes returns attrs in the order they are declared.
+func sortedAttributes(attrs hcl.Attributes) []*hcl.Attribute {
+	sorted := make([]*hcl.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		sorted = append(sorted, attr)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Range.Filename != sorted[j].Range.Filename {
+			return sorted[i].Range.Filename < sorted[j].Range.Filename
+		}
+		return sorted[i].Range.Start.Byte < sorted[j].Range.Start.Byte
+	})
+	return sorted
+}
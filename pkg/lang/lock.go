@@ -0,0 +1,390 @@
+package lang
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	LockFileName   = "omega-pkg.lock.hcl"
+	LockFileSuffix = ".lock.hcl"
+)
+
+// LockFile records the exact versions of the packages installed by the sets
+// of a config, like the dependency lock file of terraform.
+type LockFile struct {
+	Managers []*ManagerLock `hcl:"manager,block"`
+}
+
+// ManagerLock holds the locked packages of a manager block in one scope.
+// CustomManager is the custom_manager the block resolved to when the lock
+// was written.
+type ManagerLock struct {
+	Name          string         `hcl:"name,label"`
+	CustomManager string         `hcl:"custom_manager,optional"`
+	Scope         string         `hcl:"scope,optional"`
+	Packages      []*PackageLock `hcl:"package,block"`
+	Body          hcl.Body       `hcl:",body"`
+
+	// missing holds the packages without installed version.
+	missing []string
+}
+
+type PackageLock struct {
+	Name    string   `hcl:"name,label"`
+	Version string   `hcl:"version,optional"`
+	Body    hcl.Body `hcl:",body"`
+}
+
+// LockFilePath returns the path of the lock file for the config files paths,
+// next to the first of them.
+func LockFilePath(paths []string) string {
+	if len(paths) == 0 {
+		return LockFileName
+	}
+	return filepath.Join(filepath.Dir(paths[0]), LockFileName)
+}
+
+// ReadLockFile parses the lock file at path with parser.
+func ReadLockFile(parser *hclparse.Parser, path string) (*LockFile, hcl.Diagnostics) {
+	f, diags := parser.ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	lock := new(LockFile)
+	diags = append(diags, gohcl.DecodeBody(f.Body, nil, lock)...)
+	return lock, diags
+}
+
+// Write writes the lock file to path, with managers sorted by name and scope
+// and packages by name.
+func (l *LockFile) Write(path string) error {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	body.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte("# This file is maintained automatically by omega-pkg.\n")},
+		{Type: hclsyntax.TokenComment, Bytes: []byte("# Manual edits may be lost in future updates.\n")},
+	})
+	managers := append([]*ManagerLock(nil), l.Managers...)
+	sort.Slice(managers, func(i, j int) bool {
+		if managers[i].Name != managers[j].Name {
+			return managers[i].Name < managers[j].Name
+		}
+		return managers[i].Scope < managers[j].Scope
+	})
+	for _, manager := range managers {
+		body.AppendNewline()
+		block := body.AppendNewBlock("manager", []string{manager.Name}).Body()
+		if manager.CustomManager != "" {
+			block.SetAttributeValue("custom_manager", cty.StringVal(manager.CustomManager))
+		}
+		if manager.Scope != "" {
+			block.SetAttributeValue("scope", cty.StringVal(manager.Scope))
+		}
+		packages := append([]*PackageLock(nil), manager.Packages...)
+		sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+		for _, pkg := range packages {
+			pkgBlock := block.AppendNewBlock("package", []string{pkg.Name}).Body()
+			if pkg.Version != "" {
+				pkgBlock.SetAttributeValue("version", cty.StringVal(pkg.Version))
+			}
+		}
+	}
+	if err := os.WriteFile(path, f.Bytes(), 0o644); err != nil {
+		return errors.Wrap(err, "write lock file")
+	}
+	return nil
+}
+
+// Manager returns the lock of the manager block name in scope, or nil.
+func (l *LockFile) Manager(name, scope string) *ManagerLock {
+	for _, manager := range l.Managers {
+		if manager.Name == name && manager.Scope == scope {
+			return manager
+		}
+	}
+	return nil
+}
+
+// Version returns the locked version of the package name, which is "" if
+// the package was not installed when the lock was written.
+func (m *ManagerLock) Version(name string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	for _, pkg := range m.Packages {
+		if pkg.Name == name {
+			return pkg.Version, true
+		}
+	}
+	return "", false
+}
+
+// operations returns the manager blocks of the config and its modules
+// together with the custom managers they resolved to.
+func (c *Config) operations() ([]*ManagerOperation, []*CustomManager) {
+	var operations []*ManagerOperation
+	var customManagers []*CustomManager
+	for i := range c.Managers {
+		operations = append(operations, &c.Managers[i])
+		customManagers = append(customManagers, c.CustomManagerMap[c.Managers[i].Name])
+	}
+	for _, module := range c.Modules {
+		moreOperations, moreCustomManagers := module.Config.operations()
+		operations = append(operations, moreOperations...)
+		customManagers = append(customManagers, moreCustomManagers...)
+	}
+	return operations, customManagers
+}
+
+// Lock queries the installed versions of the packages of all install sets.
+// Manager blocks and sets skipped by their constraints are left out, they are
+// not checked by ApplyLock either. Manager blocks of the same name, e.g. in
+// the config and a module, are merged if they resolve to the same
+// custom_manager and are an error otherwise.
+func (c *Config) Lock(ctx context.Context) (*LockFile, error) {
+	ctx = c.WithEvalContext(ctx)
+	lock := new(LockFile)
+	operations, customManagers := c.operations()
+	for i, operation := range operations {
+		managerLocks, err := operation.Lock(ctx, customManagers[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "lock manager %s", operation.Name)
+		}
+		for _, managerLock := range managerLocks {
+			existing := lock.Manager(managerLock.Name, managerLock.Scope)
+			if existing == nil {
+				lock.Managers = append(lock.Managers, managerLock)
+				continue
+			}
+			if existing.CustomManager != managerLock.CustomManager {
+				return nil, errors.Errorf("manager %s resolves to custom_manager %s and %s",
+					managerLock.Name, existing.CustomManager, managerLock.CustomManager)
+			}
+			for _, pkg := range managerLock.Packages {
+				if _, ok := existing.Version(pkg.Name); !ok {
+					existing.Packages = append(existing.Packages, pkg)
+				}
+			}
+			existing.missing = append(existing.missing, managerLock.missing...)
+		}
+	}
+	return lock, nil
+}
+
+// Lock queries the installed versions of the packages of the install sets of
+// the manager block, with is_installed if the manager has it and with
+// list_installed otherwise. It returns a lock for every scope of the sets.
+func (m *ManagerOperation) Lock(ctx context.Context, customManager *CustomManager) ([]*ManagerLock, error) {
+	if customManager == nil {
+		return nil, errors.New("customManager is nil")
+	}
+	reason, diags := m.Constraints.Evaluate(ctx)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "evaluate constraints")
+	}
+	if reason != "" {
+		return nil, nil
+	}
+	ctx = m.withEnvironment(ctx)
+	// names holds the packages by scope, scopes the scopes in order.
+	names := make(map[string][]string)
+	var scopes []string
+	for _, set := range m.Sets {
+		if set.Action != ActionInstall {
+			continue
+		}
+		reason, diags := set.Constraints.Evaluate(ctx)
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "evaluate constraints of set")
+		}
+		if reason != "" {
+			continue
+		}
+		if _, ok := names[set.Scope]; !ok {
+			scopes = append(scopes, set.Scope)
+		}
+		for _, spec := range set.Packages {
+			names[set.Scope] = append(names[set.Scope], spec.Name)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+
+	var installed InstalledPackages
+	if _, ok := customManager.ActionMap[ActionIsInstalled]; ok {
+		installed = make(InstalledPackages, len(scopes))
+		for _, scope := range scopes {
+			infos, err := customManager.Query(ctx, ActionIsInstalled, scope, names[scope]...)
+			if err != nil {
+				return nil, errors.Wrap(err, "query installed packages")
+			}
+			installed[scope] = make(map[string]PackageInfo, len(infos))
+			for _, info := range infos {
+				installed[scope][info.Name] = info
+			}
+		}
+	} else {
+		var err error
+		if installed, err = customManager.Installed(ctx); err != nil {
+			return nil, err
+		}
+	}
+	locks := make([]*ManagerLock, 0, len(scopes))
+	for _, scope := range scopes {
+		lock := &ManagerLock{Name: m.Name, CustomManager: customManager.Name, Scope: scope}
+		for _, name := range names[scope] {
+			if _, ok := lock.Version(name); ok {
+				continue
+			}
+			info, ok := installed[scope][name]
+			if !ok || info.Version == "" {
+				zerolog.Ctx(ctx).Warn().Str("manager", m.Name).Str("package", name).
+					Msg("no installed version found, package is locked without version")
+				lock.missing = append(lock.missing, name)
+			}
+			lock.Packages = append(lock.Packages, &PackageLock{Name: name, Version: info.Version})
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// ApplyLock pins the packages of all install sets to their locked versions.
+// Manager blocks and sets skipped by their constraints are left alone, as
+// Lock leaves them out. Packages missing from the lock file, locked for
+// another custom_manager or locked to a version their own pin does not allow
+// are errors. Packages locked without version and the packages of managers
+// that cannot pin versions install unpinned, CheckLock enforcing the lock
+// after the run.
+func (c *Config) ApplyLock(ctx context.Context, lock *LockFile) hcl.Diagnostics {
+	ctx = c.WithEvalContext(ctx)
+	var diags hcl.Diagnostics
+	operations, customManagers := c.operations()
+	for i, operation := range operations {
+		if customManagers[i] == nil {
+			continue
+		}
+		reason, moreDiags := operation.Constraints.Evaluate(ctx)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() || reason != "" {
+			continue
+		}
+		managerCtx := operation.withEnvironment(ctx)
+		reported := make(map[*ManagerLock]bool)
+		for j := range operation.Sets {
+			set := &operation.Sets[j]
+			if set.Action != ActionInstall {
+				continue
+			}
+			reason, moreDiags := set.Constraints.Evaluate(managerCtx)
+			diags = append(diags, moreDiags...)
+			if moreDiags.HasErrors() || reason != "" {
+				continue
+			}
+			managerLock := lock.Manager(operation.Name, set.Scope)
+			if managerLock != nil && managerLock.CustomManager != "" && managerLock.CustomManager != customManagers[i].Name {
+				if !reported[managerLock] {
+					reported[managerLock] = true
+					diag := &hcl.Diagnostic{
+						Severity: hcl.DiagError,
+						Summary:  fmt.Sprintf("manager %s is locked for custom_manager %s", operation.Name, managerLock.CustomManager),
+						Detail: fmt.Sprintf("The lock file was written with custom_manager %s, but manager %s resolves to %s.",
+							managerLock.CustomManager, operation.Name, customManagers[i].Name),
+						Subject: operation.Body.MissingItemRange().Ptr(),
+					}
+					diags = append(diags, diag)
+				}
+				continue
+			}
+			diags = append(diags, set.applyLock(managerLock)...)
+		}
+	}
+	return diags
+}
+
+// applyLock pins the packages of the set to the versions of lock, unless
+// the manager cannot pin versions.
+func (s *Set) applyLock(lock *ManagerLock) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for i, spec := range s.Packages {
+		version, ok := lock.Version(spec.Name)
+		if !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("package %s is not locked", spec.Name),
+				Detail:   fmt.Sprintf("The lock file has no version of %s for manager %s. Run without --locked to update it.", spec.Name, s.manager.Name),
+				Subject:  spec.Range.Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		if version == "" {
+			// The package was not installed when the lock was written.
+			continue
+		}
+		if !spec.Matches(version) {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("locked version of package %s does not match its pin", spec.Name),
+				Detail:   fmt.Sprintf("The package is pinned to %s, but locked to version %s.", spec.Version, version),
+				Subject:  spec.Range.Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		if !canPinVersions(s.manager, s.action) {
+			// The manager installs whatever version it has, CheckLock
+			// reports if that is not the locked one.
+			continue
+		}
+		delete(s.args, spec.String())
+		spec.Version = version
+		arg, moreDiags := packageArgument(s.ctx, s.manager, s.action, spec)
+		diags = append(diags, moreDiags...)
+		s.args[spec.String()] = arg
+		s.Packages[i] = spec
+	}
+	return diags
+}
+
+// CheckLock compares the installed versions with the lock file and returns
+// an error listing every package that drifted from its locked version.
+func (c *Config) CheckLock(ctx context.Context, lock *LockFile) error {
+	current, err := c.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	var drifted []string
+	for _, manager := range current.Managers {
+		locked := lock.Manager(manager.Name, manager.Scope)
+		for _, pkg := range manager.Packages {
+			version, ok := locked.Version(pkg.Name)
+			if ok && version != "" && pkg.Version != "" && version != pkg.Version {
+				drifted = append(drifted, fmt.Sprintf("%s %s: %s instead of %s", manager.Name, pkg.Name, pkg.Version, version))
+			}
+		}
+		for _, name := range manager.missing {
+			if version, ok := locked.Version(name); ok && version != "" {
+				drifted = append(drifted, fmt.Sprintf("%s %s: not installed instead of %s", manager.Name, name, version))
+			}
+		}
+	}
+	if len(drifted) > 0 {
+		return errors.Errorf("installed versions drifted from the lock file: %s", strings.Join(drifted, ", "))
+	}
+	return nil
+}
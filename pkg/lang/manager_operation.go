@@ -5,49 +5,162 @@ import (
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"strings"
 )
 
 type ManagerOperation struct {
-	Name         string       `hcl:"name,label"`
-	Update       bool         `hcl:"update,optional"`
-	Cleanup      bool         `hcl:"clean,optional"`
-	DryRun       bool         `hcl:"dry,optional"`
-	Sets         []Set        `hcl:"set,block"`
-	Repositories []Repository `hcl:"repo,block"`
+	Name         string            `hcl:"name,label"`
+	Update       bool              `hcl:"update,optional"`
+	Cleanup      bool              `hcl:"clean,optional"`
+	DryRun       bool              `hcl:"dry,optional"`
+	Exclusive    bool              `hcl:"exclusive,optional"`
+	Ignores      []Ignore          `hcl:"ignore,block"`
+	Sets         []Set             `hcl:"set,block"`
+	Repositories []Repository      `hcl:"repo,block"`
+	Env          map[string]string `hcl:"env,optional"`
+	WorkingDir   string            `hcl:"working_dir,optional"`
+	CleanEnv     *bool             `hcl:"clean_env,optional"`
+	Constraints  *Constraints      `hcl:"constraints,block"`
+	Body         hcl.Body          `hcl:",body"`
+
+	// declared holds the packages of all sets for the custom_manager of an
+	// exclusive manager block, by scope.
+	declared map[string]map[string]bool
 }
 
 func (m *ManagerOperation) Run(ctx context.Context) error {
 	if m.DryRun {
 		ctx = context.WithValue(ctx, DryrunContextKey, true)
 	}
+	ctx = context.WithValue(m.withEnvironment(ctx), ManagerContextKey, m.Name)
 	customManager, ok := ctx.Value(CustomManagerContextKey).(*CustomManager)
 	if !ok {
 		return errors.New("customManager is nil")
 	}
-	if err := customManager.ActionMap["refresh"].Run(ctx); err != nil {
-		return errors.Wrap(err, "refresh packages")
+	reason, diags := m.Constraints.Evaluate(ctx)
+	if diags.HasErrors() {
+		return errors.Wrap(diags, "evaluate constraints")
+	}
+	if reason != "" {
+		zerolog.Ctx(ctx).Info().Str("manager", m.Name).Str("reason", reason).Msg("skipped manager")
+		return nil
+	}
+	repoSteps, err := m.PlanRepositories(ctx, customManager)
+	if err != nil {
+		return errors.Wrap(err, "plan repositories")
+	}
+	for _, step := range repoSteps {
+		if err := step.Apply(ctx); err != nil {
+			return errors.Wrap(err, "apply repositories")
+		}
+	}
+	// refresh is optional, as managers like go or cargo have no package
+	// index to refresh.
+	if refresh, ok := customManager.ActionMap[ActionRefresh]; ok {
+		if err := refresh.Run(ctx); err != nil {
+			return errors.Wrap(err, "refresh packages")
+		}
 	}
 	if m.Update {
-		if err := customManager.ActionMap["update"].Run(ctx); err != nil {
+		action, ok := customManager.ActionMap[ActionUpdate]
+		if !ok {
+			return errors.Errorf("manager %s has no action %s", m.Name, ActionUpdate)
+		}
+		if err := action.Run(ctx); err != nil {
 			return errors.Wrap(err, "update packages")
 		}
 	}
+	installed, err := customManager.Installed(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("manager", m.Name).
+			Msg("unable to query installed packages, running sets for all packages")
+	}
+	var changed, unchanged, skipped int
 	for _, set := range m.Sets {
 		action := customManager.ActionMap[set.Action]
 		ctx = context.WithValue(ctx, ActionContextKey, action)
-		if err := set.Run(ctx); err != nil {
+		steps, err := set.Run(ctx, installed[set.Scope])
+		if err != nil {
 			return errors.Wrapf(err, "%s packages", action.Type)
 		}
+		for _, step := range steps {
+			switch {
+			case step.Skipped != "":
+				skipped++
+			case len(step.Packages) > 0:
+				changed++
+			default:
+				unchanged++
+			}
+		}
+	}
+	zerolog.Ctx(ctx).Info().Str("manager", m.Name).
+		Int("changed", changed).Int("unchanged", unchanged).Int("skipped", skipped).
+		Msg("sets done")
+	// Removing packages no set mentions is only done by apply, after plan
+	// showed them.
+	exclusiveSteps, err := m.PlanExclusive(ctx, customManager)
+	if err != nil {
+		return errors.Wrap(err, "plan exclusive removal")
+	}
+	for _, step := range exclusiveSteps {
+		if len(step.Packages) > 0 {
+			zerolog.Ctx(ctx).Warn().Str("manager", m.Name).Str("scope", step.Scope).
+				Strs("packages", redactAll(ctx, step.Packages)).
+				Msg("not removing undeclared packages, review and remove them with plan and apply")
+		}
 	}
 	if m.Cleanup {
-		if err := customManager.ActionMap["clean"].Run(ctx); err != nil {
+		action, ok := customManager.ActionMap[ActionClean]
+		if !ok {
+			return errors.Errorf("manager %s has no action %s", m.Name, ActionClean)
+		}
+		if err := action.Run(ctx); err != nil {
 			return errors.Wrap(err, "clean packages")
 		}
 	}
 	return nil
 }
 
-func (m *ManagerOperation) PrepareSets(ctx *hcl.EvalContext, customManager *CustomManager) hcl.Diagnostics {
+// PrepareScopes checks the scopes of the sets and repositories against the
+// scopes of customManager, defaulting them to its first scope.
+func (m *ManagerOperation) PrepareScopes(customManager *CustomManager) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	check := func(scope *string, rng hcl.Range) {
+		if *scope == "" {
+			if len(customManager.Scopes) > 0 {
+				*scope = customManager.Scopes[0]
+			}
+			return
+		}
+		for _, allowed := range customManager.Scopes {
+			if allowed == *scope {
+				return
+			}
+		}
+		detail := fmt.Sprintf("The manager %s has no scopes.", m.Name)
+		if len(customManager.Scopes) > 0 {
+			detail = fmt.Sprintf("The scopes of manager %s are %s.", m.Name, strings.Join(customManager.Scopes, ", "))
+		}
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("unknown scope %s", *scope),
+			Detail:   detail,
+			Subject:  rng.Ptr(),
+		}
+		diags = append(diags, diag)
+	}
+	for i := range m.Sets {
+		check(&m.Sets[i].Scope, m.Sets[i].Range())
+	}
+	for i := range m.Repositories {
+		check(&m.Repositories[i].Scope, m.Repositories[i].Body.MissingItemRange())
+	}
+	return diags
+}
+
+func (m *ManagerOperation) PrepareSets(ctx *hcl.EvalContext, customManager *CustomManager, mappings PackageMappings) hcl.Diagnostics {
 	var diags hcl.Diagnostics
 	for i, set := range m.Sets {
 		action, ok := customManager.ActionMap[set.Action]
@@ -57,9 +170,10 @@ func (m *ManagerOperation) PrepareSets(ctx *hcl.EvalContext, customManager *Cust
 				Summary:  fmt.Sprintf("action %s does not exist on manager %s", set.Action, m.Name),
 			}
 			diags = append(diags, diag)
+			continue
 		}
 
-		moreDiags := set.Prepare(ctx.NewChild(), customManager, action)
+		moreDiags := set.Prepare(ctx.NewChild(), customManager, action, mappings)
 		diags = append(diags, moreDiags...)
 		m.Sets[i] = set
 	}
@@ -0,0 +1,191 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
+	"strings"
+)
+
+// ModuleCall is a module block. All attributes but source set the variables
+// of the module.
+type ModuleCall struct {
+	Name   string   `hcl:"name,label"`
+	Source string   `hcl:"source"`
+	Inputs hcl.Body `hcl:",remain"`
+	Body   hcl.Body `hcl:",body"`
+}
+
+type ModuleCallConfig struct {
+	Modules []*ModuleCall `hcl:"module,block"`
+	Remain  hcl.Body      `hcl:",remain"`
+}
+
+// Output is an output block of a module.
+type Output struct {
+	Name        string         `hcl:"name,label"`
+	Value       hcl.Expression `hcl:"value"`
+	Description string         `hcl:"description,optional"`
+	Sensitive   bool           `hcl:"sensitive,optional"`
+}
+
+type OutputConfig struct {
+	Outputs []*Output `hcl:"output,block"`
+	Remain  hcl.Body  `hcl:",remain"`
+}
+
+// Module is a loaded module. Its config is evaluated in its own EvalContext,
+// so it only sees its own variables, locals and functions.
+type Module struct {
+	Name      string
+	Dir       string
+	Config    *Config
+	Variables Variables
+	Outputs   map[string]cty.Value
+
+	evalCtx *hcl.EvalContext
+}
+
+// ModuleLoader loads the modules of a config. The parser keeps the files of
+// all modules for diagnostics.
+type ModuleLoader struct {
+	Parser *hclparse.Parser
+	// stack holds the directories of the modules being loaded to detect
+	// modules including themselves.
+	stack []string
+}
+
+func NewModuleLoader(parser *hclparse.Parser) *ModuleLoader {
+	return &ModuleLoader{Parser: parser}
+}
+
+// Dir resolves the source of call.
+func (call *ModuleCall) Dir() string {
+	if filepath.IsAbs(call.Source) {
+		return filepath.Clean(call.Source)
+	}
+	dir := filepath.Dir(call.Body.MissingItemRange().Filename)
+	if abs, err := filepath.Abs(filepath.Join(dir, call.Source)); err == nil {
+		return abs
+	}
+	return filepath.Join(dir, call.Source)
+}
+
+// InputAttributes returns the input attributes of call in source order.
+func (call *ModuleCall) InputAttributes() ([]*hcl.Attribute, hcl.Diagnostics) {
+	attrs, diags := call.Inputs.JustAttributes()
+	return sortedAttributes(attrs), diags
+}
+
+// Load loads the module called by call, setting its variables to inputs.
+func (l *ModuleLoader) Load(call *ModuleCall, inputs map[string]cty.Value) (*Module, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	dir := call.Dir()
+	module := &Module{Name: call.Name, Dir: dir, Outputs: make(map[string]cty.Value)}
+	for _, parent := range l.stack {
+		if parent == dir {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("module %s includes itself", call.Name),
+				Detail:   fmt.Sprintf("The module in %s is already being loaded: %s.", dir, strings.Join(append(l.stack, dir), " -> ")),
+				Subject:  call.Body.MissingItemRange().Ptr(),
+			}
+			return nil, append(diags, diag)
+		}
+	}
+	l.stack = append(l.stack, dir)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	paths, err := ConfigFilesInDir(dir)
+	if err == nil && len(paths) == 0 {
+		err = fmt.Errorf("no config files found in %s", dir)
+	}
+	if err != nil {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("unable to load module %s", call.Name),
+			Detail:   err.Error(),
+			Subject:  call.Body.MissingItemRange().Ptr(),
+		}
+		return nil, append(diags, diag)
+	}
+	bodies, moreDiags := ParseConfigFiles(l.Parser, paths)
+	diags = append(diags, moreDiags...)
+	body := hcl.MergeBodies(bodies)
+
+	ctx, err := BuildGlobalContext()
+	if err != nil {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("unable to build context of module %s", call.Name),
+			Detail:   err.Error(),
+			Subject:  call.Body.MissingItemRange().Ptr(),
+		}
+		return nil, append(diags, diag)
+	}
+	module.evalCtx = ctx
+
+	values := make(map[string]*VariableValue)
+	inputAttrs, _ := call.InputAttributes()
+	for _, attr := range inputAttrs {
+		val, ok := inputs[attr.Name]
+		if !ok {
+			continue
+		}
+		values[attr.Name] = &VariableValue{
+			Name:   attr.Name,
+			Expr:   hcl.StaticExpr(val, attr.Expr.Range()),
+			Range:  attr.Range,
+			Strict: true,
+		}
+	}
+
+	variables, modules, remain, moreDiags := DecodeGlobals(l, body, ctx, values)
+	diags = append(diags, moreDiags...)
+	module.Variables = variables
+
+	var outputs OutputConfig
+	moreDiags = gohcl.DecodeBody(remain, ctx, &outputs)
+	diags = append(diags, moreDiags...)
+	for _, output := range outputs.Outputs {
+		val, moreDiags := output.Value.Value(ctx)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			val = cty.DynamicVal
+		}
+		module.Outputs[output.Name] = val
+	}
+
+	var config Config
+	moreDiags = gohcl.DecodeBody(outputs.Remain, ctx, &config)
+	diags = append(diags, moreDiags...)
+	config.Modules = modules
+	config.Sensitive = variables.Sensitive()
+	for _, output := range outputs.Outputs {
+		if output.Sensitive {
+			config.Sensitive = config.Sensitive.Add(module.Outputs[output.Name])
+		}
+	}
+	module.Config = &config
+	return module, diags
+}
+
+// Value returns the outputs of the module as an object.
+func (m *Module) Value() cty.Value {
+	return cty.ObjectVal(m.Outputs)
+}
+
+// Validate validates the config of the module with the custom managers of
+// the config calling it in addition to its own. The module gets copies of
+// them, as preparing their actions binds them to the variables of the
+// module.
+func (m *Module) Validate(customManagers map[string]*CustomManager) hcl.Diagnostics {
+	m.Config.CustomManagerMap = make(map[string]*CustomManager, len(customManagers))
+	for name, manager := range customManagers {
+		m.Config.CustomManagerMap[name] = manager.clone()
+	}
+	return m.Config.Validate(m.evalCtx)
+}
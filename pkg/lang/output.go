@@ -0,0 +1,226 @@
+package lang
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PackageInfo is the structured result of a query action.
+type PackageInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Latest      string `json:"latest,omitempty"`
+	Repository  string `json:"repository,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Set assigns the field called name, ignoring unknown fields.
+func (p *PackageInfo) Set(name, value string) {
+	switch name {
+	case "name":
+		p.Name = value
+	case "version":
+		p.Version = value
+	case "latest":
+		p.Latest = value
+	case "repository":
+		p.Repository = value
+	case "description":
+		p.Description = value
+	}
+}
+
+// OutputParser turns the output of a query action into PackageInfo values.
+// Exactly one of three formats is used:
+//
+//   - regex: a regular expression matched against the whole output, with ^
+//     and $ matching at line boundaries. Named groups set the fields.
+//   - json: a dot separated path to an array of objects in the JSON output,
+//     "." being the document itself. keys maps fields to object keys.
+//   - columns: every non-empty line is split at separator, or at whitespace,
+//     and the columns are assigned to the fields in order, "_" skipping one.
+//
+// Without an output block, lines are split into the columns name and version.
+type OutputParser struct {
+	Regex     string            `hcl:"regex,optional"`
+	JSON      string            `hcl:"json,optional"`
+	Keys      map[string]string `hcl:"keys,optional"`
+	Columns   []string          `hcl:"columns,optional"`
+	Separator string            `hcl:"separator,optional"`
+	Skip      int               `hcl:"skip,optional"`
+	ExitCodes []int             `hcl:"exit_codes,optional"`
+	Body      hcl.Body          `hcl:",body"`
+
+	regex *regexp.Regexp
+}
+
+var defaultColumns = []string{"name", "version"}
+
+func (o *OutputParser) Validate() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if o == nil {
+		return diags
+	}
+	formats := 0
+	for _, set := range []bool{o.Regex != "", o.JSON != "", len(o.Columns) > 0} {
+		if set {
+			formats++
+		}
+	}
+	if formats > 1 {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "only one of regex, json and columns may be set on output",
+			Subject:  o.Body.MissingItemRange().Ptr(),
+		}
+		diags = append(diags, diag)
+	}
+	if o.Regex != "" {
+		regex, err := regexp.Compile("(?m)" + o.Regex)
+		if err != nil {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "invalid output regex",
+				Detail:   err.Error(),
+				Subject:  o.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+		o.regex = regex
+	}
+	return diags
+}
+
+// AllowsExitCode reports whether the output of a command exiting with code is
+// still valid. 0 is always allowed.
+func (o *OutputParser) AllowsExitCode(code int) bool {
+	if code == 0 {
+		return true
+	}
+	if o == nil {
+		return false
+	}
+	for _, allowed := range o.ExitCodes {
+		if allowed == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OutputParser) Parse(out string) ([]PackageInfo, error) {
+	if o == nil {
+		return parseColumns(out, defaultColumns, "", 0), nil
+	}
+	switch {
+	case o.Regex != "":
+		if o.regex == nil {
+			regex, err := regexp.Compile("(?m)" + o.Regex)
+			if err != nil {
+				return nil, errors.Wrap(err, "compile regex")
+			}
+			o.regex = regex
+		}
+		return parseRegex(out, o.regex), nil
+	case o.JSON != "":
+		return parseJSON(out, o.JSON, o.Keys)
+	case len(o.Columns) > 0:
+		return parseColumns(out, o.Columns, o.Separator, o.Skip), nil
+	default:
+		return parseColumns(out, defaultColumns, o.Separator, o.Skip), nil
+	}
+}
+
+func parseRegex(out string, regex *regexp.Regexp) []PackageInfo {
+	var infos []PackageInfo
+	names := regex.SubexpNames()
+	for _, match := range regex.FindAllStringSubmatch(out, -1) {
+		var info PackageInfo
+		for i, value := range match {
+			if names[i] != "" {
+				info.Set(names[i], strings.TrimSpace(value))
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func parseColumns(out string, columns []string, separator string, skip int) []PackageInfo {
+	var infos []PackageInfo
+	lines := strings.Split(out, "\n")
+	if skip > len(lines) {
+		skip = len(lines)
+	}
+	for _, line := range lines[skip:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var fields []string
+		if separator == "" {
+			fields = strings.Fields(line)
+		} else {
+			fields = strings.Split(line, separator)
+		}
+		var info PackageInfo
+		for i, column := range columns {
+			if i < len(fields) && column != "_" {
+				info.Set(column, strings.TrimSpace(fields[i]))
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func parseJSON(out, path string, keys map[string]string) ([]PackageInfo, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		return nil, errors.Wrap(err, "decode json")
+	}
+	if path != "." {
+		for _, step := range strings.Split(path, ".") {
+			switch node := doc.(type) {
+			case map[string]interface{}:
+				doc = node[step]
+			case []interface{}:
+				i, err := strconv.Atoi(step)
+				if err != nil || i < 0 || i >= len(node) {
+					return nil, errors.Errorf("invalid index %s in json path %s", step, path)
+				}
+				doc = node[i]
+			default:
+				return nil, errors.Errorf("json path %s does not exist", path)
+			}
+		}
+	}
+	list, ok := doc.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("json path %s is not an array", path)
+	}
+
+	var infos []PackageInfo
+	for _, item := range list {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var info PackageInfo
+		for _, field := range []string{"name", "version", "latest", "repository", "description"} {
+			key := field
+			if mapped, ok := keys[field]; ok {
+				key = mapped
+			}
+			if value, ok := object[key]; ok && value != nil {
+				info.Set(field, fmt.Sprint(value))
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
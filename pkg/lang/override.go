@@ -0,0 +1,277 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"strings"
+)
+
+// CustomManagerOverride is an override block patching the custom_manager of
+// the same name.
+type CustomManagerOverride struct {
+	Name   string   `hcl:"name,label"`
+	Remain hcl.Body `hcl:",remain"`
+	Body   hcl.Body `hcl:",body"`
+}
+
+// mergedBody is a body with the attributes and blocks of override taking
+// precedence over those of base. A block of override replaces the blocks of
+// base with the same type and labels, all other blocks are kept.
+type mergedBody struct {
+	base     hcl.Body
+	override hcl.Body
+}
+
+func mergeBodies(base, override hcl.Body) hcl.Body {
+	return &mergedBody{base: base, override: override}
+}
+
+// relaxSchema returns schema without required attributes, since an attribute
+// required by schema only has to be set in one of the bodies.
+func relaxSchema(schema *hcl.BodySchema) *hcl.BodySchema {
+	relaxed := &hcl.BodySchema{Blocks: schema.Blocks}
+	for _, attr := range schema.Attributes {
+		relaxed.Attributes = append(relaxed.Attributes, hcl.AttributeSchema{Name: attr.Name})
+	}
+	return relaxed
+}
+
+func (b *mergedBody) Content(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Diagnostics) {
+	baseContent, diags := b.base.Content(relaxSchema(schema))
+	overrideContent, moreDiags := b.override.Content(relaxSchema(schema))
+	diags = append(diags, moreDiags...)
+	content := b.mergeContent(baseContent, overrideContent)
+	return content, append(diags, b.checkRequired(schema, content)...)
+}
+
+func (b *mergedBody) PartialContent(schema *hcl.BodySchema) (*hcl.BodyContent, hcl.Body, hcl.Diagnostics) {
+	baseContent, baseRemain, diags := b.base.PartialContent(relaxSchema(schema))
+	overrideContent, overrideRemain, moreDiags := b.override.PartialContent(relaxSchema(schema))
+	diags = append(diags, moreDiags...)
+	content := b.mergeContent(baseContent, overrideContent)
+	diags = append(diags, b.checkRequired(schema, content)...)
+	return content, mergeBodies(baseRemain, overrideRemain), diags
+}
+
+func (b *mergedBody) JustAttributes() (hcl.Attributes, hcl.Diagnostics) {
+	attrs, diags := b.base.JustAttributes()
+	overrideAttrs, moreDiags := b.override.JustAttributes()
+	diags = append(diags, moreDiags...)
+	merged := make(hcl.Attributes, len(attrs)+len(overrideAttrs))
+	for name, attr := range attrs {
+		merged[name] = attr
+	}
+	for name, attr := range overrideAttrs {
+		merged[name] = attr
+	}
+	return merged, diags
+}
+
+func (b *mergedBody) MissingItemRange() hcl.Range {
+	return b.override.MissingItemRange()
+}
+
+func (b *mergedBody) mergeContent(base, override *hcl.BodyContent) *hcl.BodyContent {
+	content := &hcl.BodyContent{
+		Attributes:       make(hcl.Attributes),
+		MissingItemRange: b.MissingItemRange(),
+	}
+	for name, attr := range base.Attributes {
+		content.Attributes[name] = attr
+	}
+	for name, attr := range override.Attributes {
+		content.Attributes[name] = attr
+	}
+
+	overridden := make(map[string]*hcl.Block)
+	for _, block := range override.Blocks {
+		overridden[blockKey(block)] = block
+	}
+	used := make(map[string]bool)
+	for _, block := range base.Blocks {
+		key := blockKey(block)
+		if replacement, ok := overridden[key]; ok {
+			if !used[key] {
+				content.Blocks = append(content.Blocks, replacement)
+				used[key] = true
+			}
+			continue
+		}
+		content.Blocks = append(content.Blocks, block)
+	}
+	for _, block := range override.Blocks {
+		if key := blockKey(block); !used[key] {
+			content.Blocks = append(content.Blocks, block)
+			used[key] = true
+		}
+	}
+	return content
+}
+
+func (b *mergedBody) checkRequired(schema *hcl.BodySchema, content *hcl.BodyContent) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, attr := range schema.Attributes {
+		if _, ok := content.Attributes[attr.Name]; attr.Required && !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing required argument",
+				Detail:   fmt.Sprintf("The argument %q is required, but no definition was found.", attr.Name),
+				Subject:  b.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+	}
+	return diags
+}
+
+func blockKey(block *hcl.Block) string {
+	return strings.Join(append([]string{block.Type}, block.Labels...), "\x00")
+}
+
+// decodeCustomManager decodes body as the custom_manager name.
+func decodeCustomManager(name string, body hcl.Body, ctx *hcl.EvalContext) (*CustomManager, hcl.Diagnostics) {
+	manager := &CustomManager{Name: name}
+	diags := gohcl.DecodeBody(body, ctx, manager)
+	manager.Name = name
+	return manager, diags
+}
+
+// resolveCustomManagers applies extends, override blocks and disabled to the
+// custom managers of the config. The managers already in CustomManagerMap,
+// such as those of the config calling a module, can be extended but not
+// redeclared. A custom_manager may only reuse the name of an earlier one if
+// it extends it or disables it.
+func (c *Config) resolveCustomManagers(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	declared := make(map[string]*CustomManager, len(c.CustomManagerMap))
+	for name, manager := range c.CustomManagerMap {
+		declared[name] = manager
+	}
+	var order []string
+	var pending []*CustomManager
+	for _, manager := range c.CustomManagers {
+		previous, exists := declared[manager.Name]
+		switch {
+		case !exists && manager.Extends == "":
+			declared[manager.Name] = manager
+			order = append(order, manager.Name)
+		case !exists:
+			pending = append(pending, manager)
+			order = append(order, manager.Name)
+		case manager.Extends == manager.Name:
+			merged, moreDiags := decodeCustomManager(manager.Name, mergeBodies(previous.Body, manager.Body), ctx)
+			diags = append(diags, moreDiags...)
+			declared[manager.Name] = merged
+			order = append(order, manager.Name)
+		case manager.Disabled && manager.Extends == "":
+			disabled := *previous
+			disabled.Disabled = true
+			declared[manager.Name] = &disabled
+		default:
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("duplicate custom_manager %s", manager.Name),
+				Detail: fmt.Sprintf(
+					"A custom_manager named %s is already declared at %s. Use extends = %q to extend it, an override block to patch it or disabled = true to hide it.",
+					manager.Name, previous.Body.MissingItemRange(), manager.Name,
+				),
+				Subject: manager.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+	}
+
+	// Overrides of managers declared without extends are applied first, so
+	// managers extending them inherit the patched definition.
+	applied := make(map[*CustomManagerOverride]bool)
+	applyOverrides := func(last bool) {
+		for _, override := range c.Overrides {
+			if applied[override] {
+				continue
+			}
+			manager, ok := declared[override.Name]
+			if !ok && !last {
+				continue
+			} else if !ok {
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("override of unknown custom_manager %s", override.Name),
+					Subject:  override.Body.MissingItemRange().Ptr(),
+				}
+				diags = append(diags, diag)
+				continue
+			}
+			merged, moreDiags := decodeCustomManager(override.Name, mergeBodies(manager.Body, override.Body), ctx)
+			diags = append(diags, moreDiags...)
+			declared[override.Name] = merged
+			order = append(order, override.Name)
+			applied[override] = true
+		}
+	}
+	applyOverrides(false)
+
+	// Managers extending others are resolved once all names are known, so
+	// they may extend managers declared after them.
+	var resolve func(manager *CustomManager, stack []string) hcl.Diagnostics
+	resolve = func(manager *CustomManager, stack []string) hcl.Diagnostics {
+		var diags hcl.Diagnostics
+		for _, name := range stack {
+			if name == manager.Name {
+				diag := &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  fmt.Sprintf("custom_manager %s extends itself", manager.Name),
+					Detail:   fmt.Sprintf("The custom managers extend each other: %s.", strings.Join(append(stack, manager.Name), " -> ")),
+					Subject:  manager.Body.MissingItemRange().Ptr(),
+				}
+				return append(diags, diag)
+			}
+		}
+		parent, ok := declared[manager.Extends]
+		if !ok {
+			for _, candidate := range pending {
+				if candidate.Name == manager.Extends {
+					diags = append(diags, resolve(candidate, append(stack, manager.Name))...)
+					parent, ok = declared[manager.Extends]
+				}
+			}
+		}
+		if diags.HasErrors() {
+			return diags
+		}
+		if !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("custom_manager %s extends unknown custom_manager %s", manager.Name, manager.Extends),
+				Subject:  manager.Body.MissingItemRange().Ptr(),
+			}
+			return append(diags, diag)
+		}
+		merged, moreDiags := decodeCustomManager(manager.Name, mergeBodies(parent.Body, manager.Body), ctx)
+		diags = append(diags, moreDiags...)
+		declared[manager.Name] = merged
+		return diags
+	}
+	for _, manager := range pending {
+		if _, done := declared[manager.Name]; !done {
+			diags = append(diags, resolve(manager, nil)...)
+		}
+	}
+	applyOverrides(true)
+
+	c.CustomManagers = nil
+	c.CustomManagerMap = make(map[string]*CustomManager, len(declared))
+	seen := make(map[string]bool)
+	for name, manager := range declared {
+		if !manager.Disabled {
+			c.CustomManagerMap[name] = manager
+		}
+	}
+	for _, name := range order {
+		if manager, ok := c.CustomManagerMap[name]; ok && !seen[name] {
+			c.CustomManagers = append(c.CustomManagers, manager)
+			seen[name] = true
+		}
+	}
+	return diags
+}
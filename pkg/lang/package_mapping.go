@@ -0,0 +1,95 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// PackageMapping is a package block giving the names of a package per
+// custom_manager, used by sets with logical = true.
+type PackageMapping struct {
+	Name  string   `hcl:"name,label"`
+	Names hcl.Body `hcl:",remain"`
+	Body  hcl.Body `hcl:",body"`
+
+	names map[string][]string
+}
+
+// PackageMappings holds the package blocks by logical name.
+type PackageMappings map[string]*PackageMapping
+
+// decode evaluates the names of the mapping.
+func (p *PackageMapping) decode(ctx *hcl.EvalContext) hcl.Diagnostics {
+	p.names = make(map[string][]string)
+	attrs, diags := p.Names.JustAttributes()
+	for _, attr := range sortedAttributes(attrs) {
+		val, moreDiags := attr.Expr.Value(ctx)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			continue
+		}
+		if val.Type() == cty.String {
+			val = cty.ListVal([]cty.Value{val})
+		}
+		val, err := convert.Convert(val, cty.List(cty.String))
+		if err == nil && val.IsNull() {
+			err = fmt.Errorf("must not be null")
+		}
+		var names []string
+		if err == nil {
+			err = gocty.FromCtyValue(val, &names)
+		}
+		if err != nil {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("invalid name of package %s for manager %s", p.Name, attr.Name),
+				Detail:   fmt.Sprintf("The name must be a string or a list of strings: %s.", err),
+				Subject:  attr.Expr.Range().Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		p.names[attr.Name] = names
+	}
+	return diags
+}
+
+// packageMappings decodes the package blocks of the config.
+func (c *Config) packageMappings(ctx *hcl.EvalContext) (PackageMappings, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	mappings := make(PackageMappings, len(c.Packages))
+	for _, mapping := range c.Packages {
+		if previous, ok := mappings[mapping.Name]; ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("duplicate package %s", mapping.Name),
+				Detail:   fmt.Sprintf("The package %s is already mapped at %s.", mapping.Name, previous.Body.MissingItemRange()),
+				Subject:  mapping.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		diags = append(diags, mapping.decode(ctx)...)
+		mappings[mapping.Name] = mapping
+	}
+	return mappings, diags
+}
+
+// Resolve returns the names of the logical package name for manager, trying
+// the manager it extends if it has no names of its own. ok is false if the
+// package is not mapped for the manager.
+func (m PackageMappings) Resolve(manager *CustomManager, name string) (names []string, ok bool) {
+	mapping, found := m[name]
+	if !found {
+		return nil, false
+	}
+	for _, candidate := range []string{manager.Name, manager.Extends} {
+		if names, ok := mapping.names[candidate]; ok && candidate != "" {
+			return names, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,174 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+	"path"
+	"sort"
+)
+
+// PackageSpec is an entry of the packages of a set, either a package name or
+// an object with a name, a version glob and flags.
+type PackageSpec struct {
+	Name    string
+	Version string
+	Flags   []string
+	Range   hcl.Range
+}
+
+// String returns the name of the package, followed by @version if it is
+// pinned.
+func (p PackageSpec) String() string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s@%s", p.Name, p.Version)
+}
+
+// Matches reports whether the installed version satisfies the pin of the
+// package. An unpinned package or an unknown installed version always match.
+func (p PackageSpec) Matches(version string) bool {
+	if p.Version == "" || version == "" || p.Version == version {
+		return true
+	}
+	ok, _ := path.Match(p.Version, version)
+	return ok
+}
+
+var packageSpecAttributes = map[string]cty.Type{
+	"name":    cty.String,
+	"version": cty.String,
+	"flags":   cty.List(cty.String),
+}
+
+// decodePackageSpecs evaluates the packages attribute of a set. If split is
+// true, string entries written as name@version are pinned to that version,
+// as for managers with a package attribute.
+func decodePackageSpecs(expr hcl.Expression, ctx *hcl.EvalContext, split bool) ([]PackageSpec, hcl.Diagnostics) {
+	val, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	if val.IsNull() || !val.IsKnown() || !val.CanIterateElements() || val.Type().IsMapType() || val.Type().IsObjectType() {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "invalid packages",
+			Detail:   "The packages must be a list of package names or objects with name, version and flags.",
+			Subject:  expr.Range().Ptr(),
+		}
+		return nil, append(diags, diag)
+	}
+	exprs, listDiags := hcl.ExprList(expr)
+	var specs []PackageSpec
+	for it, i := val.ElementIterator(), 0; it.Next(); i++ {
+		_, elem := it.Element()
+		rng := expr.Range()
+		if !listDiags.HasErrors() && i < len(exprs) {
+			rng = exprs[i].Range()
+		}
+		spec, moreDiags := decodePackageSpec(elem, rng, split)
+		diags = append(diags, moreDiags...)
+		if !moreDiags.HasErrors() {
+			specs = append(specs, spec)
+		}
+	}
+	return specs, diags
+}
+
+func decodePackageSpec(val cty.Value, rng hcl.Range, split bool) (PackageSpec, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	spec := PackageSpec{Range: rng}
+	invalid := func(detail string) hcl.Diagnostics {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "invalid package",
+			Detail:   detail,
+			Subject:  rng.Ptr(),
+		}
+		return append(diags, diag)
+	}
+	switch {
+	case val.IsNull():
+		return spec, invalid("A package must not be null.")
+	case val.Type() == cty.String:
+		spec.Name = val.AsString()
+		if split {
+			spec.Name, spec.Version = SplitPackage(spec.Name)
+		}
+	case val.Type().IsObjectType() || val.Type().IsMapType():
+		attrs := val.AsValueMap()
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ty, ok := packageSpecAttributes[name]
+			if !ok {
+				return spec, invalid(fmt.Sprintf("Unsupported attribute %q, a package has only name, version and flags.", name))
+			}
+			attr, err := convert.Convert(attrs[name], ty)
+			if err == nil && !attr.IsNull() {
+				switch name {
+				case "name":
+					err = gocty.FromCtyValue(attr, &spec.Name)
+				case "version":
+					err = gocty.FromCtyValue(attr, &spec.Version)
+				case "flags":
+					err = gocty.FromCtyValue(attr, &spec.Flags)
+				}
+			}
+			if err != nil {
+				return spec, invalid(fmt.Sprintf("Invalid value for %s: %s.", name, err))
+			}
+		}
+	default:
+		return spec, invalid("A package must be a name or an object with name, version and flags.")
+	}
+	if spec.Name == "" {
+		return spec, invalid("A package must have a name.")
+	}
+	return spec, diags
+}
+
+// packageArgument returns the argument passing spec to the command of action.
+// Pinned packages are written as name@version for the package attribute of
+// the action or manager, or formatted by the version_format of the manager.
+// Managers with neither cannot pin versions.
+func packageArgument(ctx *hcl.EvalContext, manager *CustomManager, action *Action, spec PackageSpec) (string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	switch {
+	case spec.Version == "":
+		return spec.Name, diags
+	case packageExpr(manager, action) != nil:
+		return spec.String(), diags
+	case !canPinVersions(manager, action):
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("manager %s cannot pin versions", manager.Name),
+			Detail: fmt.Sprintf("The package %s is pinned to version %s, but custom_manager %s has no version_format.",
+				spec.Name, spec.Version, manager.Name),
+			Subject: spec.Range.Ptr(),
+		}
+		return "", append(diags, diag)
+	}
+	pkgCtx := ctx.NewChild()
+	pkgCtx.Variables = map[string]cty.Value{
+		"name":    cty.StringVal(spec.Name),
+		"version": cty.StringVal(spec.Version),
+	}
+	var arg string
+	diags = gohcl.DecodeExpression(manager.VersionFormat, pkgCtx, &arg)
+	return arg, diags
+}
+
+// canPinVersions reports whether action of manager can install a package in a
+// given version, through the package attribute or the version_format of the
+// manager.
+func canPinVersions(manager *CustomManager, action *Action) bool {
+	return packageExpr(manager, action) != nil || isSet(manager.VersionFormat)
+}
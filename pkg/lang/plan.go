@@ -0,0 +1,416 @@
+package lang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Plan describes every command a run would execute, together with the
+// package state it was computed from. A saved Plan can be applied later to
+// run exactly these commands.
+type Plan struct {
+	Managers []*ManagerPlan `json:"managers"`
+	Commands []*PlanStep    `json:"commands,omitempty"`
+
+	redactor Redactor
+}
+
+// ManagerPlan holds the steps of a manager block. CustomManager names the
+// custom_manager it resolved to if that differs from Name, as for manager
+// "system".
+type ManagerPlan struct {
+	Name          string      `json:"name"`
+	CustomManager string      `json:"custom_manager,omitempty"`
+	DryRun        bool        `json:"dryrun,omitempty"`
+	Range         hcl.Range   `json:"range"`
+	Steps         []*PlanStep `json:"steps"`
+	Skipped       string      `json:"skipped,omitempty"`
+}
+
+// PlanStep is a single command of a Plan. Packages holds the packages the
+// step changes, Unchanged the packages of the set that are already in the
+// desired state. A step without Command has nothing to do, Skipped holds the
+// reason if the constraints of the step did not match. Exclusive marks the
+// step removing the packages an exclusive manager block does not declare in
+// Scope. Scope is set on exclusive and rollback steps of managers with scopes.
+type PlanStep struct {
+	Action        string               `json:"action"`
+	Packages      []string             `json:"packages,omitempty"`
+	Unchanged     []string             `json:"unchanged,omitempty"`
+	Command       []string             `json:"command,omitempty"`
+	Range         hcl.Range            `json:"range"`
+	PackageRanges map[string]hcl.Range `json:"package_ranges,omitempty"`
+	Skipped       string               `json:"skipped,omitempty"`
+	Exclusive     bool                 `json:"exclusive,omitempty"`
+	Scope         string               `json:"scope,omitempty"`
+	Environment
+}
+
+func (c *Config) Plan(ctx context.Context) (*Plan, error) {
+	ctx = c.WithEvalContext(ctx)
+	plan := &Plan{redactor: c.Sensitive}
+	for _, manager := range c.Managers {
+		customManager := c.CustomManagerMap[manager.Name]
+		managerPlan, err := manager.Plan(ctx, customManager)
+		if err != nil {
+			return nil, errors.Wrapf(err, "plan manager %s", manager.Name)
+		}
+		plan.Managers = append(plan.Managers, managerPlan)
+	}
+	for _, command := range c.Commands {
+		step := &PlanStep{
+			Action: "command",
+			Range:  command.Body.MissingItemRange(),
+		}
+		reason, diags := command.Constraints.Evaluate(ctx)
+		if diags.HasErrors() {
+			return nil, errors.Wrap(diags, "evaluate constraints of command")
+		}
+		if reason != "" {
+			step.Skipped = reason
+		} else {
+			step.Command = command.Argv()
+			step.Environment = command.Environment(ctx)
+		}
+		plan.Commands = append(plan.Commands, step)
+	}
+	for _, module := range c.Modules {
+		modulePlan, err := module.Config.Plan(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "plan module %s", module.Name)
+		}
+		plan.Managers = append(plan.Managers, modulePlan.Managers...)
+		plan.Commands = append(plan.Commands, modulePlan.Commands...)
+		plan.redactor = append(plan.redactor, modulePlan.redactor...)
+	}
+	return plan, nil
+}
+
+func (m *ManagerOperation) Plan(ctx context.Context, customManager *CustomManager) (*ManagerPlan, error) {
+	if customManager == nil {
+		return nil, errors.New("customManager is nil")
+	}
+	reason, diags := m.Constraints.Evaluate(ctx)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "evaluate constraints")
+	}
+	if reason != "" {
+		return &ManagerPlan{Name: m.Name, Range: m.Body.MissingItemRange(), Skipped: reason}, nil
+	}
+	ctx = m.withEnvironment(ctx)
+	installed, err := customManager.Installed(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("manager", m.Name).
+			Msg("unable to query installed packages, assuming none are installed")
+	}
+
+	plan := &ManagerPlan{
+		Name:   m.Name,
+		DryRun: m.DryRun,
+		Range:  m.Body.MissingItemRange(),
+	}
+	if customManager.Name != m.Name {
+		plan.CustomManager = customManager.Name
+	}
+	repoSteps, err := m.PlanRepositories(ctx, customManager)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan repositories")
+	}
+	plan.Steps = append(plan.Steps, repoSteps...)
+	if _, ok := customManager.ActionMap[ActionRefresh]; ok {
+		plan.Steps = append(plan.Steps, customManager.actionStep(ctx, ActionRefresh))
+	}
+	if m.Update {
+		plan.Steps = append(plan.Steps, customManager.actionStep(ctx, ActionUpdate))
+	}
+	for _, set := range m.Sets {
+		steps, diags := set.Plan(ctx, installed[set.Scope])
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "plan set of action %s", set.Action)
+		}
+		plan.Steps = append(plan.Steps, steps...)
+	}
+	exclusiveSteps, err := m.PlanExclusive(ctx, customManager)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan exclusive removal")
+	}
+	plan.Steps = append(plan.Steps, exclusiveSteps...)
+	if m.Cleanup {
+		plan.Steps = append(plan.Steps, customManager.actionStep(ctx, ActionClean))
+	}
+	return plan, nil
+}
+
+func (m *CustomManager) actionStep(ctx context.Context, name string) *PlanStep {
+	step := &PlanStep{Action: name}
+	if action, ok := m.ActionMap[name]; ok {
+		step.Command = action.command
+		step.Environment = action.environment(ctx)
+		step.Range = action.Remain.MissingItemRange()
+	}
+	return step
+}
+
+// Plan compares the packages of the set with the installed packages. Only
+// missing packages, or packages whose installed version does not match their
+// pin, are installed and only present packages are removed; all packages of
+// other actions, or all packages if installed is nil, change. Packages with
+// flags of their own get a step each.
+func (s *Set) Plan(ctx context.Context, installed map[string]PackageInfo) ([]*PlanStep, hcl.Diagnostics) {
+	newStep := func() *PlanStep {
+		return &PlanStep{
+			Action:        s.Action,
+			Range:         s.Range(),
+			PackageRanges: s.PackageRanges(),
+		}
+	}
+	step := newStep()
+	reason, diags := s.Constraints.Evaluate(ctx)
+	if diags.HasErrors() || reason != "" {
+		for _, spec := range s.Packages {
+			step.Packages = append(step.Packages, spec.String())
+		}
+		step.Skipped = reason
+		return []*PlanStep{step}, diags
+	}
+	env := actionEnvironment(ctx, s.manager, s.action, s.Scope).Merge(s.Env, s.WorkingDir, s.CleanEnv)
+	var changed []PackageSpec
+	var steps []*PlanStep
+	for _, spec := range s.Packages {
+		changes := true
+		if installed != nil {
+			info, present := installed[spec.Name]
+			switch s.Action {
+			case ActionInstall:
+				changes = !present || !spec.Matches(info.Version)
+			case ActionRemove:
+				changes = present
+			}
+		}
+		switch {
+		case !changes:
+			step.Unchanged = append(step.Unchanged, spec.String())
+		case len(spec.Flags) > 0:
+			flagged := newStep()
+			flagged.Packages = []string{spec.String()}
+			command, moreDiags := s.buildCommand([]PackageSpec{spec}, spec.Flags)
+			diags = append(diags, moreDiags...)
+			flagged.Command = command
+			flagged.Environment = env
+			steps = append(steps, flagged)
+		default:
+			changed = append(changed, spec)
+			step.Packages = append(step.Packages, spec.String())
+		}
+	}
+	if len(changed) > 0 {
+		command, moreDiags := s.buildCommand(changed, nil)
+		diags = append(diags, moreDiags...)
+		step.Command = command
+		step.Environment = env
+	}
+	if len(step.Packages) > 0 || len(step.Unchanged) > 0 || len(steps) == 0 {
+		steps = append([]*PlanStep{step}, steps...)
+	}
+	return steps, diags
+}
+
+func (p *Plan) Apply(ctx context.Context) error {
+	for _, manager := range p.Managers {
+		if err := manager.Apply(ctx); err != nil {
+			return errors.Wrapf(err, "apply manager %s", manager.Name)
+		}
+	}
+	for _, command := range p.Commands {
+		if err := command.Apply(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ManagerPlan) Apply(ctx context.Context) error {
+	if m.DryRun {
+		ctx = context.WithValue(ctx, DryrunContextKey, true)
+	}
+	ctx = context.WithValue(ctx, ManagerContextKey, m.Name)
+	for _, step := range m.Steps {
+		if err := step.Apply(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PlanStep) Apply(ctx context.Context) error {
+	if len(s.Command) == 0 {
+		return nil
+	}
+	ctx = context.WithValue(s.Environment.WithContext(ctx), StepContextKey, s)
+	if _, err := runCommand(ctx, s.Command[0], s.Command[1:]...); err != nil {
+		return errors.Wrapf(err, "run %s", s.Action)
+	}
+	return nil
+}
+
+// Write prints a human-readable summary of the plan.
+func (p *Plan) Write(w io.Writer) error {
+	var toInstall, toRemove, present int
+	b := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, manager := range p.Managers {
+		if manager.CustomManager != "" {
+			fmt.Fprintf(b, "manager %q (%s, %s)\n", manager.Name, manager.CustomManager, formatRange(manager.Range))
+		} else {
+			fmt.Fprintf(b, "manager %q (%s)\n", manager.Name, formatRange(manager.Range))
+		}
+		if manager.Skipped != "" {
+			fmt.Fprintf(b, "  # skipped: %s\n", manager.Skipped)
+			continue
+		}
+		if manager.DryRun {
+			fmt.Fprintln(b, "  (dry run, commands are only printed)")
+		}
+		for _, step := range manager.Steps {
+			if step.Skipped != "" {
+				fmt.Fprintf(b, "  # %s\tskipped: %s\n", strings.Join(append([]string{step.Action}, step.Packages...), " "), step.Skipped)
+				continue
+			}
+			if step.Exclusive {
+				where := ""
+				if step.Scope != "" {
+					where = " in " + step.Scope
+				}
+				if len(step.Packages) == 0 {
+					fmt.Fprintf(b, "  # exclusive%s\tno undeclared packages\n", where)
+				}
+				for _, pkg := range step.Packages {
+					fmt.Fprintf(b, "  - %s %s (not declared%s, exclusive)\t%s\n", step.Action, p.redactor.Redact(pkg), where, formatRange(step.Range))
+				}
+				toRemove += len(step.Packages)
+				continue
+			}
+			switch step.Action {
+			case ActionAddRepo, ActionRemoveRepo:
+				symbol, unchanged := "+", "already present"
+				if step.Action == ActionRemoveRepo {
+					symbol, unchanged = "-", "already absent"
+				}
+				for _, repo := range step.Packages {
+					fmt.Fprintf(b, "  %s %s %s\t%s\n", symbol, step.Action, repo, formatRange(step.Range))
+				}
+				for _, repo := range step.Unchanged {
+					fmt.Fprintf(b, "  = %s %s (%s)\t%s\n", step.Action, repo, unchanged, formatRange(step.Range))
+				}
+			case ActionInstall, ActionRemove:
+				symbol, unchanged := "+", "already present"
+				if step.Action == ActionRemove {
+					symbol, unchanged = "-", "already absent"
+				}
+				where := ""
+				if step.Scope != "" {
+					where = " in " + step.Scope
+				}
+				for _, pkg := range step.Packages {
+					fmt.Fprintf(b, "  %s %s %s%s\t%s\n", symbol, step.Action, p.redactor.Redact(pkg), where, formatRange(step.PackageRanges[pkg]))
+				}
+				for _, pkg := range step.Unchanged {
+					fmt.Fprintf(b, "  = %s %s (%s)\t%s\n", step.Action, p.redactor.Redact(pkg), unchanged, formatRange(step.PackageRanges[pkg]))
+				}
+				if step.Action == ActionInstall {
+					toInstall += len(step.Packages)
+				} else {
+					toRemove += len(step.Packages)
+				}
+				present += len(step.Unchanged)
+			default:
+				if len(step.Command) == 0 {
+					fmt.Fprintf(b, "  ? %s (not defined on manager)\n", step.Action)
+					continue
+				}
+				fmt.Fprintf(b, "  ~ %s\t%s\n", strings.Join(append([]string{step.Action}, step.Packages...), " "), formatRange(step.Range))
+			}
+		}
+	}
+	for _, command := range p.Commands {
+		if command.Skipped != "" {
+			fmt.Fprintf(b, "command (%s)\n  # skipped: %s\n", formatRange(command.Range), command.Skipped)
+			continue
+		}
+		fmt.Fprintf(b, "command (%s)\n  ! %s\n", formatRange(command.Range), p.redactor.Redact(strings.Join(command.Command, " ")))
+	}
+	fmt.Fprintf(b, "\nPlan: %d to install, %d to remove, %d unchanged.\n", toInstall, toRemove, present)
+
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "write plan")
+	}
+	return nil
+}
+
+func formatRange(r hcl.Range) string {
+	if r.Filename == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s:%d", r.Filename, r.Start.Line)
+}
+
+// Save writes the plan to path, readable only by its owner. A saved plan
+// holds the commands as they run, so plans containing the values of
+// sensitive variables are not saved.
+func (p *Plan) Save(path string) error {
+	if p.containsSensitive() {
+		return errors.New("plan contains sensitive values and cannot be saved")
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal plan")
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return errors.Wrap(err, "write plan file")
+	}
+	// WriteFile keeps the mode of an existing file.
+	if err := os.Chmod(path, 0o600); err != nil {
+		return errors.Wrap(err, "write plan file")
+	}
+	return nil
+}
+
+// containsSensitive reports whether a step of the plan holds the value of a
+// sensitive variable.
+func (p *Plan) containsSensitive() bool {
+	steps := p.Commands
+	for _, manager := range p.Managers {
+		steps = append(steps, manager.Steps...)
+	}
+	for _, step := range steps {
+		values := []string{step.WorkingDir}
+		for _, list := range [][]string{step.Packages, step.Unchanged, step.Command, step.Env} {
+			values = append(values, list...)
+		}
+		for _, value := range values {
+			if p.redactor.Redact(value) != value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read plan file")
+	}
+	plan := new(Plan)
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, errors.Wrap(err, "unmarshal plan")
+	}
+	return plan, nil
+}
@@ -0,0 +1,292 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/userfunc"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/samber/lo"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"strings"
+)
+
+var funcSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "func", LabelNames: []string{"name"}},
+	},
+}
+
+// refNode is a local, variable, module or user function in the reference
+// graph. Its key is the way it is referenced, e.g. local.foo, vars.bar,
+// module.qux or func.baz.
+type refNode struct {
+	key   string
+	rng   hcl.Range
+	exprs []hcl.Expression
+	eval  func() hcl.Diagnostics
+	// fail marks the node as not evaluable because a dependency failed.
+	fail func()
+}
+
+type refGraph struct {
+	nodes map[string]*refNode
+	order []*refNode
+	state map[string]int
+}
+
+const (
+	refUnvisited = iota
+	refVisiting
+	refDone
+	refFailed
+)
+
+func (g *refGraph) add(node *refNode) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if previous, ok := g.nodes[node.key]; ok {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("duplicate declaration of %s", node.key),
+			Detail:   fmt.Sprintf("%s was already declared at %s.", node.key, previous.rng),
+			Subject:  node.rng.Ptr(),
+		}
+		return append(diags, diag)
+	}
+	g.nodes[node.key] = node
+	g.order = append(g.order, node)
+	return diags
+}
+
+// dependencies returns the keys of the nodes the expressions of node
+// reference, either by a local, vars or module traversal or by calling a user
+// function.
+func (g *refGraph) dependencies(node *refNode) []string {
+	var deps []string
+	seen := make(map[string]bool)
+	addDep := func(key string) {
+		if _, ok := g.nodes[key]; ok && !seen[key] {
+			seen[key] = true
+			deps = append(deps, key)
+		}
+	}
+	for _, expr := range node.exprs {
+		for _, traversal := range expr.Variables() {
+			root := traversal.RootName()
+			if (root != "local" && root != "vars" && root != "module") || len(traversal) < 2 {
+				continue
+			}
+			switch step := traversal[1].(type) {
+			case hcl.TraverseAttr:
+				addDep(root + "." + step.Name)
+			case hcl.TraverseIndex:
+				if step.Key.Type() == cty.String && step.Key.IsKnown() {
+					addDep(root + "." + step.Key.AsString())
+				}
+			}
+		}
+		if syntaxExpr, ok := hcl.UnwrapExpression(expr).(hclsyntax.Expression); ok {
+			hclsyntax.VisitAll(syntaxExpr, func(n hclsyntax.Node) hcl.Diagnostics {
+				if call, ok := n.(*hclsyntax.FunctionCallExpr); ok {
+					addDep("func." + call.Name)
+				}
+				return nil
+			})
+		}
+	}
+	return deps
+}
+
+// visit evaluates the dependencies of the node with key and then the node
+// itself. stack holds the keys of the nodes currently being visited and is
+// used to describe cycles. It reports whether the node was evaluated.
+func (g *refGraph) visit(key string, stack []string) (bool, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	node := g.nodes[key]
+	switch g.state[key] {
+	case refDone:
+		return true, diags
+	case refFailed:
+		return false, diags
+	case refVisiting:
+		start := lo.IndexOf(stack, key)
+		cycle := append(append([]string{}, stack[start:]...), key)
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("cycle in references of %s", key),
+			Detail:   fmt.Sprintf("The references form a cycle: %s.", strings.Join(cycle, " -> ")),
+			Subject:  node.rng.Ptr(),
+		}
+		return false, append(diags, diag)
+	}
+
+	g.state[key] = refVisiting
+	stack = append(stack, key)
+	ok := true
+	for _, dep := range g.dependencies(node) {
+		depOk, moreDiags := g.visit(dep, stack)
+		diags = append(diags, moreDiags...)
+		ok = ok && depOk
+	}
+	if !ok {
+		g.state[key] = refFailed
+		if node.fail != nil {
+			node.fail()
+		}
+		return false, diags
+	}
+	if node.eval != nil {
+		moreDiags := node.eval()
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			g.state[key] = refFailed
+			return false, diags
+		}
+	}
+	g.state[key] = refDone
+	return true, diags
+}
+
+// DecodeGlobals decodes the user functions, locals, variables and modules of
+// body into ctx. Variables are set to their entry in values or to their
+// default, modules are loaded with loader. Locals, variable defaults, module
+// inputs and function results may reference each other in any order, they
+// are evaluated along their references and cycles are reported. Variable
+// validations are checked once all variables are set.
+func DecodeGlobals(loader *ModuleLoader, body hcl.Body, ctx *hcl.EvalContext, values map[string]*VariableValue) (
+	variables Variables, modules []*Module, remain hcl.Body, diags hcl.Diagnostics,
+) {
+	userfuncs, remain, moreDiags := userfunc.DecodeUserFunctions(body, "func", func() *hcl.EvalContext { return ctx })
+	diags = append(diags, moreDiags...)
+	ctx.Functions = lo.Assign[string, function.Function](userfuncs, ctx.Functions)
+
+	locals, remain, moreDiags := DecodeLocals(remain, ctx)
+	diags = append(diags, moreDiags...)
+
+	variables, remain, moreDiags = DecodeVariable(remain, ctx, values)
+	diags = append(diags, moreDiags...)
+
+	var calls ModuleCallConfig
+	moreDiags = gohcl.DecodeBody(remain, ctx, &calls)
+	diags = append(diags, moreDiags...)
+	remain = calls.Remain
+
+	localValues := make(map[string]cty.Value)
+	varValues := make(map[string]cty.Value)
+	moduleValues := make(map[string]cty.Value)
+	ctx.Variables["local"] = cty.ObjectVal(localValues)
+	ctx.Variables["vars"] = cty.ObjectVal(varValues)
+	ctx.Variables["module"] = cty.ObjectVal(moduleValues)
+
+	graph := &refGraph{nodes: make(map[string]*refNode), state: make(map[string]int)}
+
+	// The func blocks were already decoded, this only finds their results.
+	funcContent, _, _ := body.PartialContent(funcSchema)
+	for _, block := range funcContent.Blocks {
+		attrs, _ := block.Body.JustAttributes()
+		var exprs []hcl.Expression
+		if result, ok := attrs["result"]; ok {
+			exprs = append(exprs, result.Expr)
+		}
+		moreDiags = graph.add(&refNode{key: "func." + block.Labels[0], rng: block.DefRange, exprs: exprs})
+		diags = append(diags, moreDiags...)
+	}
+
+	for _, attr := range locals {
+		attr := attr
+		moreDiags = graph.add(&refNode{
+			key:   "local." + attr.Name,
+			rng:   attr.NameRange,
+			exprs: []hcl.Expression{attr.Expr},
+			eval: func() hcl.Diagnostics {
+				val, diags := attr.Expr.Value(ctx)
+				if diags.HasErrors() {
+					val = cty.DynamicVal
+				}
+				localValues[attr.Name] = val
+				ctx.Variables["local"] = cty.ObjectVal(localValues)
+				return diags
+			},
+			fail: func() {
+				localValues[attr.Name] = cty.DynamicVal
+				ctx.Variables["local"] = cty.ObjectVal(localValues)
+			},
+		})
+		diags = append(diags, moreDiags...)
+	}
+
+	for _, variable := range variables {
+		variable := variable
+		var exprs []hcl.Expression
+		if variable.Value.Type() == cty.NilType && isSet(variable.DefaultValue) {
+			exprs = append(exprs, variable.DefaultValue)
+		}
+		moreDiags = graph.add(&refNode{
+			key:   "vars." + variable.Name,
+			rng:   variable.Body.MissingItemRange(),
+			exprs: exprs,
+			eval: func() hcl.Diagnostics {
+				diags := variable.ApplyDefault(ctx)
+				varValues[variable.Name] = variable.Value
+				ctx.Variables["vars"] = cty.ObjectVal(varValues)
+				return diags
+			},
+			fail: func() {
+				variable.Value = cty.DynamicVal
+				varValues[variable.Name] = variable.Value
+				ctx.Variables["vars"] = cty.ObjectVal(varValues)
+			},
+		})
+		diags = append(diags, moreDiags...)
+	}
+
+	for _, call := range calls.Modules {
+		call := call
+		inputAttrs, moreDiags := call.InputAttributes()
+		diags = append(diags, moreDiags...)
+		exprs := make([]hcl.Expression, 0, len(inputAttrs))
+		for _, attr := range inputAttrs {
+			exprs = append(exprs, attr.Expr)
+		}
+		moreDiags = graph.add(&refNode{
+			key:   "module." + call.Name,
+			rng:   call.Body.MissingItemRange(),
+			exprs: exprs,
+			eval: func() hcl.Diagnostics {
+				var diags hcl.Diagnostics
+				inputs := make(map[string]cty.Value)
+				for _, attr := range inputAttrs {
+					val, moreDiags := attr.Expr.Value(ctx)
+					diags = append(diags, moreDiags...)
+					inputs[attr.Name] = val
+				}
+				moduleValues[call.Name] = cty.DynamicVal
+				if !diags.HasErrors() {
+					module, moreDiags := loader.Load(call, inputs)
+					diags = append(diags, moreDiags...)
+					if module != nil {
+						modules = append(modules, module)
+						moduleValues[call.Name] = module.Value()
+					}
+				}
+				ctx.Variables["module"] = cty.ObjectVal(moduleValues)
+				return diags
+			},
+			fail: func() {
+				moduleValues[call.Name] = cty.DynamicVal
+				ctx.Variables["module"] = cty.ObjectVal(moduleValues)
+			},
+		})
+		diags = append(diags, moreDiags...)
+	}
+
+	for _, node := range graph.order {
+		_, moreDiags = graph.visit(node.key, nil)
+		diags = append(diags, moreDiags...)
+	}
+
+	moreDiags = variables.Validate(ctx)
+	diags = append(diags, moreDiags...)
+	return
+}
@@ -0,0 +1,162 @@
+package lang_test
+
+import (
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"omega-pkg/pkg/lang"
+	"strings"
+	"testing"
+)
+
+// TestDecodeGlobals evaluates locals, variables and user functions declared
+// in any order and checks the values they reference each other with.
+func TestDecodeGlobals(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		vars []string
+		// want holds the expected values of the locals and variables as
+		// local.<name> or vars.<name>.
+		want map[string]cty.Value
+		// err is a substring of the expected error, if any.
+		err string
+	}{
+		{
+			name: "local referencing a later local",
+			src: `
+locals {
+  a = local.b + 1
+  b = 1
+}`,
+			want: map[string]cty.Value{"local.a": cty.NumberIntVal(2), "local.b": cty.NumberIntVal(1)},
+		},
+		{
+			name: "local referencing a variable",
+			src: `
+locals {
+  doubled = vars.x * 2
+}
+variable "x" {
+  type    = number
+  default = 2
+}`,
+			want: map[string]cty.Value{"local.doubled": cty.NumberIntVal(4), "vars.x": cty.NumberIntVal(2)},
+		},
+		{
+			name: "variable default referencing a later local",
+			src: `
+variable "x" {
+  type    = string
+  default = "${local.base}-x"
+}
+locals {
+  base = "b"
+}`,
+			want: map[string]cty.Value{"vars.x": cty.StringVal("b-x")},
+		},
+		{
+			name: "variable value replacing a default referencing a local",
+			src: `
+variable "x" {
+  type    = number
+  default = local.base
+}
+locals {
+  base    = 1
+  doubled = vars.x * 2
+}`,
+			vars: []string{"x=5"},
+			want: map[string]cty.Value{"vars.x": cty.NumberIntVal(5), "local.doubled": cty.NumberIntVal(10)},
+		},
+		{
+			name: "local calling a later function",
+			src: `
+locals {
+  result = double(3)
+}
+func "double" {
+  params = [n]
+  result = n * 2
+}`,
+			want: map[string]cty.Value{"local.result": cty.NumberIntVal(6)},
+		},
+		{
+			name: "function referencing a later local",
+			src: `
+locals {
+  out  = prefixed("x")
+  base = "b"
+}
+func "prefixed" {
+  params = [s]
+  result = "${local.base}-${s}"
+}`,
+			want: map[string]cty.Value{"local.out": cty.StringVal("b-x")},
+		},
+		{
+			name: "cycle of locals",
+			src: `
+locals {
+  a = local.b
+  b = local.a
+}`,
+			err: "local.a -> local.b -> local.a",
+		},
+		{
+			name: "cycle of a local and a variable",
+			src: `
+variable "x" {
+  default = local.y
+}
+locals {
+  y = vars.x
+}`,
+			err: "local.y -> vars.x -> local.y",
+		},
+		{
+			name: "duplicate local",
+			src: `
+locals {
+  a = 1
+}
+locals {
+  a = 2
+}`,
+			err: "duplicate local a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := hclparse.NewParser()
+			file, diags := parser.ParseHCL([]byte(tt.src), "test.hcl")
+			if diags.HasErrors() {
+				t.Fatalf("parse: %s", diags)
+			}
+			ctx, err := lang.BuildGlobalContext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, diags := lang.CollectVariableValues(parser, nil, nil, tt.vars)
+			if diags.HasErrors() {
+				t.Fatalf("collect variable values: %s", diags)
+			}
+			_, _, _, diags = lang.DecodeGlobals(lang.NewModuleLoader(parser), file.Body, ctx, values)
+			if tt.err != "" {
+				if !diags.HasErrors() || !strings.Contains(diags.Error(), tt.err) {
+					t.Fatalf("got diagnostics %v, want an error containing %q", diags, tt.err)
+				}
+				return
+			}
+			if diags.HasErrors() {
+				t.Fatalf("decode globals: %s", diags)
+			}
+			for key, want := range tt.want {
+				root, name, _ := strings.Cut(key, ".")
+				got := ctx.Variables[root].GetAttr(name)
+				if !got.RawEquals(want) {
+					t.Errorf("%s = %#v, want %#v", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,155 @@
 package lang
 
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Repository is a package repository of a manager. The add_repo, remove_repo
+// and import_key actions of the CustomManager are evaluated with the
+// repository available as repo.name, repo.url, repo.type, repo.key and
+// repo.scope. What url, type and key contain depends on the manager, e.g. a
+// key id for pacman and a key url for apt and apk.
 type Repository struct {
 	Name        string       `hcl:"name,label"`
 	Url         string       `hcl:"url"`
 	Type        string       `hcl:"type,optional"`
 	Key         string       `hcl:"key,optional"`
+	Scope       string       `hcl:"scope,optional"`
+	Remove      bool         `hcl:"remove,optional"`
 	Constraints *Constraints `hcl:"constraints,block"`
+	Body        hcl.Body     `hcl:",body"`
+}
+
+func (r *Repository) Value() cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"name":  cty.StringVal(r.Name),
+		"url":   cty.StringVal(r.Url),
+		"type":  cty.StringVal(r.Type),
+		"key":   cty.StringVal(r.Key),
+		"scope": cty.StringVal(r.Scope),
+	})
+}
+
+// Plan returns the steps needed to add or remove the repository. present
+// holds the names or urls of the configured repositories as reported by the
+// list_repos action; if it is nil the repository is always added or removed.
+func (r *Repository) Plan(ctx context.Context, manager *CustomManager, present map[string]bool) ([]*PlanStep, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var steps []*PlanStep
+	exists := present == nil && r.Remove || present[r.Name] || present[r.Url]
+
+	var actions []string
+	switch {
+	case r.Remove && exists:
+		actions = []string{ActionRemoveRepo}
+	case !r.Remove && !exists && r.Key != "":
+		actions = []string{ActionImportKey, ActionAddRepo}
+	case !r.Remove && !exists:
+		actions = []string{ActionAddRepo}
+	}
+	if len(actions) == 0 {
+		action := ActionAddRepo
+		if r.Remove {
+			action = ActionRemoveRepo
+		}
+		step := &PlanStep{Action: action, Unchanged: []string{r.Name}, Range: r.Body.MissingItemRange()}
+		return []*PlanStep{step}, diags
+	}
+
+	for _, name := range actions {
+		action, ok := manager.ActionMap[name]
+		if !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("action %s does not exist on manager %s", name, manager.Name),
+				Subject:  r.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		if action.ctx == nil {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("action %s of manager %s is not prepared", name, manager.Name),
+				Subject:  r.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		evalCtx := action.ctx.NewChild()
+		evalCtx.Variables = map[string]cty.Value{"repo": r.Value()}
+		command, moreDiags := buildCommand(evalCtx, manager, action, r.Scope, nil, nil, nil)
+		diags = append(diags, moreDiags...)
+		steps = append(steps, &PlanStep{
+			Action:      name,
+			Packages:    []string{r.Name},
+			Command:     command,
+			Range:       r.Body.MissingItemRange(),
+			Environment: actionEnvironment(ctx, manager, action, r.Scope),
+		})
+	}
+	return steps, diags
+}
+
+// PresentRepositories queries the configured repositories with the
+// list_repos action, by scope and name. It returns nil if the manager has no
+// such action.
+func (m *CustomManager) PresentRepositories(ctx context.Context) (map[string]map[string]bool, error) {
+	if _, ok := m.ActionMap[ActionListRepos]; !ok {
+		return nil, nil
+	}
+	present := make(map[string]map[string]bool)
+	for _, scope := range m.scopes() {
+		infos, err := m.Query(ctx, ActionListRepos, scope)
+		if err != nil {
+			return nil, errors.Wrap(err, "list repositories")
+		}
+		present[scope] = make(map[string]bool, len(infos))
+		for _, info := range infos {
+			present[scope][info.Name] = true
+		}
+	}
+	return present, nil
+}
+
+func (m *ManagerOperation) PlanRepositories(ctx context.Context, customManager *CustomManager) ([]*PlanStep, error) {
+	var steps []*PlanStep
+	if len(m.Repositories) == 0 {
+		return steps, nil
+	}
+	present, err := customManager.PresentRepositories(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("manager", m.Name).
+			Msg("unable to query repositories, assuming none are configured")
+	}
+	for _, repo := range m.Repositories {
+		reason, diags := repo.Constraints.Evaluate(ctx)
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "evaluate constraints of repository %s", repo.Name)
+		}
+		if reason != "" {
+			action := ActionAddRepo
+			if repo.Remove {
+				action = ActionRemoveRepo
+			}
+			steps = append(steps, &PlanStep{
+				Action:   action,
+				Packages: []string{repo.Name},
+				Range:    repo.Body.MissingItemRange(),
+				Skipped:  reason,
+			})
+			continue
+		}
+		repoSteps, diags := repo.Plan(ctx, customManager, present[repo.Scope])
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "plan repository %s", repo.Name)
+		}
+		steps = append(steps, repoSteps...)
+	}
+	return steps, nil
 }
@@ -0,0 +1,249 @@
+package lang
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"omega-pkg/pkg/state"
+	"sort"
+)
+
+// Snapshot queries the installed packages of every manager block of the
+// config and its modules, leaving out the blocks skipped by their
+// constraints. Only the packages rollback manages are recorded, see
+// managedPackages, with an entry for every scope of a manager.
+func (c *Config) Snapshot(ctx context.Context) (*state.Generation, error) {
+	ctx = c.WithEvalContext(ctx)
+	generation := new(state.Generation)
+	operations, customManagers := c.operations()
+	for i, operation := range operations {
+		if customManagers[i] == nil || generation.Manager(operation.Name) != nil {
+			continue
+		}
+		reason, diags := operation.Constraints.Evaluate(ctx)
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "evaluate constraints of manager %s", operation.Name)
+		}
+		if reason != "" {
+			continue
+		}
+		managerCtx := operation.withEnvironment(ctx)
+		installed, err := customManagers[i].Installed(managerCtx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "snapshot manager %s", operation.Name)
+		}
+		managed, err := customManagers[i].managedPackages(managerCtx, operation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "snapshot manager %s", operation.Name)
+		}
+		for _, scope := range customManagers[i].scopes() {
+			manager := &state.GenerationManager{
+				Name:          operation.Name,
+				CustomManager: customManagers[i].Name,
+				Scope:         scope,
+				Packages:      make(map[string]string),
+			}
+			for name := range managed[scope] {
+				if info, ok := installed[scope][name]; ok {
+					manager.Packages[name] = info.Version
+				}
+			}
+			generation.Managers = append(generation.Managers, manager)
+		}
+	}
+	return generation, nil
+}
+
+// managedPackages returns the names of the packages rollback manages for the
+// manager block operation, by scope: the explicitly installed packages if the
+// manager has a list_explicit action and the packages the sets of operation
+// declare otherwise. The dependencies of these packages are left to the
+// package manager. operation may be nil.
+func (m *CustomManager) managedPackages(ctx context.Context, operation *ManagerOperation) (map[string]map[string]bool, error) {
+	managed := make(map[string]map[string]bool)
+	for _, scope := range m.scopes() {
+		managed[scope] = make(map[string]bool)
+	}
+	if _, ok := m.ActionMap[ActionListExplicit]; ok {
+		for _, scope := range m.scopes() {
+			infos, err := m.Query(ctx, ActionListExplicit, scope)
+			if err != nil {
+				return nil, errors.Wrap(err, "list explicitly installed packages")
+			}
+			for _, info := range infos {
+				managed[scope][info.Name] = true
+			}
+		}
+		return managed, nil
+	}
+	if operation == nil {
+		return managed, nil
+	}
+	for _, set := range operation.Sets {
+		for _, spec := range set.Packages {
+			if managed[set.Scope] != nil {
+				managed[set.Scope][spec.Name] = true
+			}
+		}
+	}
+	return managed, nil
+}
+
+// PlanRollback plans the commands taking the installed packages back to
+// generation, scope by scope: managed packages installed since are removed,
+// packages removed since are installed and packages whose version changed are
+// installed pinned to their version in the generation, with the downgrade
+// action if the manager has one. Version changes of managers that cannot pin
+// versions are left as they are.
+func (c *Config) PlanRollback(ctx context.Context, generation *state.Generation) (*Plan, error) {
+	ctx = c.WithEvalContext(ctx)
+	plan := &Plan{redactor: c.Sensitive}
+	for _, target := range generation.Managers {
+		customManager, ok := c.CustomManagerMap[target.CustomManager]
+		if !ok {
+			return nil, errors.Errorf("custom_manager %s of manager %s does not exist", target.CustomManager, target.Name)
+		}
+		managerCtx := ctx
+		operation := c.operation(target.Name)
+		if operation != nil {
+			managerCtx = operation.withEnvironment(ctx)
+		}
+		managerPlan, err := customManager.planRollback(managerCtx, c, operation, target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "plan rollback of manager %s", target.Name)
+		}
+		plan.Managers = append(plan.Managers, managerPlan)
+	}
+	return plan, nil
+}
+
+// operation returns the manager block name of the config or its modules, or
+// nil.
+func (c *Config) operation(name string) *ManagerOperation {
+	operations, _ := c.operations()
+	for _, operation := range operations {
+		if operation.Name == name {
+			return operation
+		}
+	}
+	return nil
+}
+
+// planRollback plans the rollback of the scope of target. Packages missing
+// from target are only removed if rollback manages them, see
+// managedPackages, so dependencies installed along the way stay.
+func (m *CustomManager) planRollback(
+	ctx context.Context, c *Config, operation *ManagerOperation, target *state.GenerationManager,
+) (*ManagerPlan, error) {
+	plan := &ManagerPlan{Name: target.Name}
+	if m.Name != target.Name {
+		plan.CustomManager = m.Name
+	}
+	names := []string{ActionInstall, ActionRemove}
+	if _, ok := m.ActionMap[ActionDowngrade]; ok {
+		names = append(names, ActionDowngrade)
+	}
+	for _, name := range names {
+		if diags := m.PrepareAction(c.evalCtx, name); diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "prepare action %s", name)
+		}
+	}
+	scope := target.Scope
+	if scope == "" {
+		scope = m.defaultScope()
+	}
+	scopes, err := m.Installed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	installed := scopes[scope]
+	managed, err := m.managedPackages(ctx, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	install := m.ActionMap[ActionInstall]
+	downgrade, ok := m.ActionMap[ActionDowngrade]
+	if !ok {
+		downgrade = install
+	}
+	var toInstall, toDowngrade []PackageSpec
+	var toRemove []string
+	for name, version := range target.Packages {
+		info, present := installed[name]
+		spec := PackageSpec{Name: name, Version: version}
+		switch {
+		case !present && !canPinVersions(m, install):
+			spec.Version = ""
+			toInstall = append(toInstall, spec)
+		case !present:
+			toInstall = append(toInstall, spec)
+		case version == "" || info.Version == "" || version == info.Version:
+		case !canPinVersions(m, downgrade):
+			zerolog.Ctx(ctx).Warn().Str("manager", target.Name).Str("package", name).
+				Str("version", info.Version).Str("target", version).
+				Msg("manager cannot pin versions, keeping installed version")
+		default:
+			toDowngrade = append(toDowngrade, spec)
+		}
+	}
+	for name := range managed[scope] {
+		_, wanted := target.Packages[name]
+		if _, present := installed[name]; present && !wanted {
+			toRemove = append(toRemove, name)
+		}
+	}
+	sort.Strings(toRemove)
+
+	if len(toRemove) > 0 {
+		remove := m.ActionMap[ActionRemove]
+		command, diags := buildCommand(remove.ctx, m, remove, scope, nil, nil, toRemove)
+		if diags.HasErrors() {
+			return nil, errors.Wrap(diags, "build command of action remove")
+		}
+		plan.Steps = append(plan.Steps, &PlanStep{
+			Action:      ActionRemove,
+			Packages:    toRemove,
+			Command:     command,
+			Scope:       target.Scope,
+			Environment: actionEnvironment(ctx, m, remove, scope),
+		})
+	}
+	for _, group := range []struct {
+		action *Action
+		specs  []PackageSpec
+	}{{install, toInstall}, {downgrade, toDowngrade}} {
+		if len(group.specs) == 0 {
+			continue
+		}
+		step, err := m.pinnedStep(ctx, group.action, scope, group.specs)
+		if err != nil {
+			return nil, err
+		}
+		step.Scope = target.Scope
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// pinnedStep returns the step running action in scope for the packages,
+// pinned to their versions.
+func (m *CustomManager) pinnedStep(ctx context.Context, action *Action, scope string, specs []PackageSpec) (*PlanStep, error) {
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	step := &PlanStep{Action: ActionInstall, Environment: actionEnvironment(ctx, m, action, scope)}
+	args := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		arg, diags := packageArgument(action.ctx, m, action, spec)
+		if diags.HasErrors() {
+			return nil, errors.Wrapf(diags, "pin package %s", spec.Name)
+		}
+		args = append(args, arg)
+		step.Packages = append(step.Packages, spec.String())
+	}
+	command, diags := buildCommand(action.ctx, m, action, scope, nil, nil, args)
+	if diags.HasErrors() {
+		return nil, errors.Wrapf(diags, "build command of action %s", action.Type)
+	}
+	step.Command = command
+	return step, nil
+}
@@ -0,0 +1,93 @@
+package lang
+
+import (
+	"context"
+	"github.com/zclconf/go-cty/cty"
+	"strings"
+)
+
+const redacted = "(sensitive)"
+
+// Redactor hides the values of sensitive variables in printed command lines.
+type Redactor []string
+
+// Add returns the redactor extended by the strings in val, including those
+// nested in collections. Numbers and bools are not redacted.
+func (r Redactor) Add(val cty.Value) Redactor {
+	if val.IsNull() || !val.IsKnown() {
+		return r
+	}
+	switch {
+	case val.Type() == cty.String:
+		if s := val.AsString(); s != "" {
+			r = append(r, s)
+		}
+	case val.CanIterateElements():
+		for it := val.ElementIterator(); it.Next(); {
+			_, elem := it.Element()
+			r = r.Add(elem)
+		}
+	}
+	return r
+}
+
+// minSubstringLength is the length from which sensitive values are redacted
+// wherever they appear. Shorter values, such as "1" or "true", are only
+// redacted as whole words so they do not mask unrelated text.
+const minSubstringLength = 8
+
+// Redact replaces the sensitive values in s.
+func (r Redactor) Redact(s string) string {
+	for _, secret := range r {
+		if len(secret) >= minSubstringLength {
+			s = strings.ReplaceAll(s, secret, redacted)
+		} else {
+			s = replaceWord(s, secret, redacted)
+		}
+	}
+	return s
+}
+
+// replaceWord replaces the occurrences of old in s that are not part of a
+// longer word, e.g. of a version such as 1.2.1 or a name such as true-color.
+func replaceWord(s, old, repl string) string {
+	var b strings.Builder
+	start := 0
+	for {
+		i := strings.Index(s[start:], old)
+		if i < 0 {
+			break
+		}
+		i += start
+		end := i + len(old)
+		if (i == 0 || !isWordByte(s[i-1])) && (end == len(s) || !isWordByte(s[end])) {
+			b.WriteString(s[:i])
+			b.WriteString(repl)
+			s, start = s[end:], 0
+		} else {
+			start = i + 1
+		}
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.IndexByte("_.-", c) >= 0
+}
+
+func redact(ctx context.Context, s string) string {
+	if r, ok := ctx.Value(SensitiveContextKey).(Redactor); ok {
+		return r.Redact(s)
+	}
+	return s
+}
+
+// redactAll redacts every string of values.
+func redactAll(ctx context.Context, values []string) []string {
+	redacted := make([]string, 0, len(values))
+	for _, s := range values {
+		redacted = append(redacted, redact(ctx, s))
+	}
+	return redacted
+}
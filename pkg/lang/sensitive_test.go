@@ -0,0 +1,35 @@
+package lang_test
+
+import (
+	"github.com/zclconf/go-cty/cty"
+	"omega-pkg/pkg/lang"
+	"testing"
+)
+
+func TestRedactorRedact(t *testing.T) {
+	r := lang.Redactor(nil).Add(cty.ListVal([]cty.Value{
+		cty.StringVal("s3cr3t-token"),
+		cty.StringVal("1"),
+		cty.StringVal("true"),
+		cty.StringVal("bob"),
+	}))
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"curl -H Authorization:s3cr3t-token", "curl -H Authorization:(sensitive)"},
+		{"--token=xs3cr3t-tokenx", "--token=x(sensitive)x"},
+		{"apt-get install foo=1.2.1", "apt-get install foo=1.2.1"},
+		{"--jobs 1", "--jobs (sensitive)"},
+		{"--retries=1 --level=11", "--retries=(sensitive) --level=11"},
+		{"--color=true-color", "--color=true-color"},
+		{"--force=true", "--force=(sensitive)"},
+		{"/home/bob/bin bobby", "/home/(sensitive)/bin bobby"},
+		{"1 1", "(sensitive) (sensitive)"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -6,18 +6,32 @@ import (
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 	"github.com/zclconf/go-cty/cty"
-	"github.com/zclconf/go-cty/cty/gocty"
-	"omega-pkg/pkg/utils"
-	"strings"
 )
 
 type Set struct {
-	Action      string   `hcl:"action,label"`
-	Packages    []string `hcl:"packages"`
-	command     []string
-	Constraints *Constraints `hcl:"constraints,block"`
-	Remain      hcl.Body     `hcl:",remain"`
+	Action       string            `hcl:"action,label"`
+	PackagesExpr hcl.Expression    `hcl:"packages"`
+	Scope        string            `hcl:"scope,optional"`
+	Logical      bool              `hcl:"logical,optional"`
+	Strict       bool              `hcl:"strict,optional"`
+	Env          map[string]string `hcl:"env,optional"`
+	WorkingDir   string            `hcl:"working_dir,optional"`
+	CleanEnv     *bool             `hcl:"clean_env,optional"`
+	Constraints  *Constraints      `hcl:"constraints,block"`
+	Body         hcl.Body          `hcl:",body"`
+	Remain       hcl.Body          `hcl:",remain"`
+
+	// Packages holds the packages of the set once it is prepared, with
+	// logical names resolved.
+	Packages []PackageSpec
+
+	ctx     *hcl.EvalContext
+	manager *CustomManager
+	action  *Action
+	// args holds the command argument of every package.
+	args map[string]string
 }
 type SetRemain struct {
 	FlagExpr hcl.Expression `hcl:"flags,optional"`
@@ -26,14 +40,9 @@ type SetRemain struct {
 var SetSpec = hcldec.ObjectSpec{
 	"packages": &hcldec.AttrSpec{
 		Name:     "packages",
-		Type:     cty.List(cty.String),
+		Type:     cty.DynamicPseudoType,
 		Required: true,
 	},
-	"constraints": &hcldec.BlockSpec{
-		TypeName: "constraints",
-		Nested:   ConstraintSpec,
-		Required: false,
-	},
 	"action": &hcldec.BlockLabelSpec{
 		Index: 0,
 		Name:  "action",
@@ -48,73 +57,136 @@ var SetRemainSpec = hcldec.ObjectSpec{
 }
 
 func (s *Set) Prepare(
-	ctx *hcl.EvalContext, manager *CustomManager, action *Action,
+	ctx *hcl.EvalContext, manager *CustomManager, action *Action, mappings PackageMappings,
 ) (diags hcl.Diagnostics) {
-	pkgs, err := gocty.ToCtyValue(s.Packages, cty.List(cty.String))
-	if err != nil {
-		diag := &hcl.Diagnostic{
-			Severity: hcl.DiagError,
-			Summary:  "error converting list of packages to cty value",
-		}
-		diags = append(diags, diag)
+	s.ctx = ctx.NewChild()
+	s.ctx.Variables = map[string]cty.Value{"scope": cty.StringVal(s.Scope)}
+	s.manager = manager
+	s.action = action
+	specs, diags := decodePackageSpecs(s.PackagesExpr, s.ctx, packageExpr(manager, action) != nil)
+	if diags.HasErrors() {
+		return diags
 	}
-	ctx.Variables = map[string]cty.Value{"pkgs": pkgs}
-
-	managerRemain, moreDiags := hcldec.Decode(manager.Remain, CustomManagerRemainSpec, ctx)
-	diags = append(diags, moreDiags...)
-	globalCmd := utils.ValueToString(managerRemain.GetAttr("cmd"))
-	managerFlags := utils.MapValueToString(managerRemain.GetAttr("flags"))
-
-	actionRemain, moreDiags := hcldec.Decode(action.Remain, ActionRemainSpec, ctx)
-	diags = append(diags, moreDiags...)
-	actionCmd := utils.ValueToString(actionRemain.GetAttr("cmd"))
-	actionFlags := utils.MapValueToString(actionRemain.GetAttr("flags"))
-	actionInline := utils.MapValueToString(actionRemain.GetAttr("inline"))
-
-	setRemain, moreDiags := hcldec.Decode(s.Remain, ActionRemainSpec, ctx)
-	diags = append(diags, moreDiags...)
-	setFlags := utils.MapValueToString(setRemain.GetAttr("flags"))
-
-	var flags []string
-	if len(actionInline) == 0 {
-		flags = append(managerFlags, append(actionFlags, setFlags...)...)
+	s.Packages = specs
+	diags = append(diags, s.resolvePackages(mappings)...)
+	s.args = make(map[string]string, len(s.Packages))
+	for _, spec := range s.Packages {
+		arg, moreDiags := packageArgument(s.ctx, manager, action, spec)
+		diags = append(diags, moreDiags...)
+		s.args[spec.String()] = arg
 	}
+	if diags.HasErrors() {
+		return diags
+	}
+	_, moreDiags := s.buildCommand(s.Packages, nil)
+	return append(diags, moreDiags...)
+}
 
-	if len(actionInline) > 0 {
-		if actionCmd == "" {
-			actionCmd = "/bin/sh"
-			flags = []string{"-c"}
+// resolvePackages replaces the logical package names of a set with logical =
+// true by the names mapped for its manager. Unmapped names are used as they
+// are with a warning, or are an error if the set is strict. The resolved
+// packages keep the version and flags of the logical package.
+func (s *Set) resolvePackages(mappings PackageMappings) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	if !s.Logical {
+		return diags
+	}
+	seen := make(map[string]bool)
+	resolved := make([]PackageSpec, 0, len(s.Packages))
+	for _, spec := range s.Packages {
+		names, ok := mappings.Resolve(s.manager, spec.Name)
+		if !ok {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  fmt.Sprintf("package %s has no name for manager %s", spec.Name, s.manager.Name),
+				Detail:   fmt.Sprintf("No package block maps %s for manager %s, so the name is used as it is.", spec.Name, s.manager.Name),
+				Subject:  spec.Range.Ptr(),
+			}
+			if s.Strict {
+				diag.Severity = hcl.DiagError
+				diag.Detail = fmt.Sprintf("Add a package block mapping %s for manager %s.", spec.Name, s.manager.Name)
+			}
+			diags = append(diags, diag)
+			names = []string{spec.Name}
 		}
-		flags = append(flags, append(actionFlags, strings.Join(actionInline, "\n"))...)
-	} else if actionCmd == "" && globalCmd != "" {
-		actionCmd = globalCmd
-	} else {
-
-		diag := &hcl.Diagnostic{
-			Severity: hcl.DiagWarning,
-			Summary:  fmt.Sprintf("no global command and no command or inline defined on action %s", action.Type),
+		for _, name := range names {
+			pkg := spec
+			pkg.Name = name
+			if !seen[pkg.String()] {
+				seen[pkg.String()] = true
+				resolved = append(resolved, pkg)
+			}
 		}
-		diags = append(diags, diag)
 	}
-	s.command = append([]string{actionCmd}, flags...)
+	s.Packages = resolved
+	return diags
+}
 
-	command := strings.Join(s.command, " ")
-	appendPackages := true
-	for _, s2 := range s.Packages {
-		if strings.Contains(command, s2) {
-			appendPackages = false
-		}
-	}
-	if appendPackages {
-		s.command = append(s.command, s.Packages...)
+// buildCommand assembles the command line of the set for the given packages
+// with the extra flags of the packages.
+func (s *Set) buildCommand(packages []PackageSpec, flags []string) ([]string, hcl.Diagnostics) {
+	args := make([]string, 0, len(packages))
+	for _, spec := range packages {
+		args = append(args, s.args[spec.String()])
 	}
+	return buildCommand(s.ctx, s.manager, s.action, s.Scope, s.Remain, flags, args)
+}
 
-	return diags
+// Range returns the location of the set block.
+func (s *Set) Range() hcl.Range {
+	return s.Body.MissingItemRange()
 }
 
-func (s *Set) Run(ctx context.Context) error {
-	if _, err := runCommand(ctx, s.command[0], s.command[1:]...); err != nil {
-		return errors.Wrapf(err, "run command on set of action %s", s.Action)
+// PackageRanges returns the location of every package of the set. Resolved
+// logical names have the location of the logical name.
+func (s *Set) PackageRanges() map[string]hcl.Range {
+	ranges := make(map[string]hcl.Range, len(s.Packages))
+	for _, spec := range s.Packages {
+		ranges[spec.String()] = spec.Range
+	}
+	return ranges
+}
+
+// Run installs the missing or removes the present packages of the set and
+// skips the commands if all packages already are in the desired state. The
+// installed packages are updated to reflect the changes; if installed is nil
+// the commands are run for all packages.
+func (s *Set) Run(ctx context.Context, installed map[string]PackageInfo) ([]*PlanStep, error) {
+	steps, diags := s.Plan(ctx, installed)
+	if diags.HasErrors() {
+		return nil, errors.Wrapf(diags, "plan set of action %s", s.Action)
+	}
+	specs := make(map[string]PackageSpec, len(s.Packages))
+	for _, spec := range s.Packages {
+		specs[spec.String()] = spec
+	}
+	for _, step := range steps {
+		if step.Skipped != "" {
+			zerolog.Ctx(ctx).Info().Str("action", s.Action).Str("reason", step.Skipped).Msg("skipped")
+			continue
+		}
+		logger := zerolog.Ctx(ctx).With().Str("action", s.Action).Strs("unchanged", redactAll(ctx, step.Unchanged)).Logger()
+		if len(step.Packages) == 0 {
+			logger.Info().Msg("ok")
+			continue
+		}
+		if err := step.Apply(ctx); err != nil {
+			return nil, errors.Wrapf(err, "run command on set of action %s", s.Action)
+		}
+		logger.Info().Strs("changed", redactAll(ctx, step.Packages)).Msg("changed")
+
+		if installed == nil {
+			continue
+		}
+		for _, pkg := range step.Packages {
+			spec := specs[pkg]
+			switch s.Action {
+			case ActionInstall:
+				installed[spec.Name] = PackageInfo{Name: spec.Name, Version: spec.Version}
+			case ActionRemove:
+				delete(installed, spec.Name)
+			}
+		}
 	}
-	return nil
+	return steps, nil
 }
@@ -0,0 +1,90 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// InvokingUser returns the user omega-pkg runs on behalf of: the user that
+// called sudo if it runs as root through sudo, the current user otherwise.
+func InvokingUser() (*user.User, error) {
+	if name := os.Getenv("SUDO_USER"); name != "" && name != "root" && os.Geteuid() == 0 {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "look up user %s", name)
+		}
+		return u, nil
+	}
+	u, err := user.Current()
+	if err != nil {
+		return nil, errors.Wrap(err, "get current user")
+	}
+	return u, nil
+}
+
+// ForUser returns e running as u. HOME, USER and LOGNAME are set for u and
+// the directories of path, with a leading ~ expanded to the home of u, are
+// prepended to PATH. Variables already in e take precedence.
+func (e Environment) ForUser(u *user.User, path []string) Environment {
+	dirs := make([]string, 0, len(path)+1)
+	for _, dir := range path {
+		if dir == "~" || strings.HasPrefix(dir, "~/") {
+			dir = filepath.Join(u.HomeDir, dir[1:])
+		}
+		dirs = append(dirs, dir)
+	}
+	if current := os.Getenv("PATH"); current != "" {
+		dirs = append(dirs, current)
+	}
+	env := []string{
+		fmt.Sprintf("HOME=%s", u.HomeDir),
+		fmt.Sprintf("USER=%s", u.Username),
+		fmt.Sprintf("LOGNAME=%s", u.Username),
+		fmt.Sprintf("PATH=%s", strings.Join(dirs, string(os.PathListSeparator))),
+	}
+	merged := Environment{User: u.Username}.Override(Environment{Env: env})
+	return merged.Override(e)
+}
+
+// credential returns the credential to run commands as the user name. It
+// returns nil if no switch is needed or possible, i.e. if omega-pkg does not
+// run as root or already runs as that user.
+func credential(name string) (*syscall.Credential, error) {
+	if name == "" || os.Geteuid() != 0 {
+		return nil, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "look up user %s", name)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse uid of user %s", name)
+	}
+	if uid == 0 {
+		return nil, nil
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse gid of user %s", name)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Wrapf(err, "list groups of user %s", name)
+	}
+	for _, id := range groupIds {
+		group, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse group id of user %s", name)
+		}
+		cred.Groups = append(cred.Groups, uint32(group))
+	}
+	return cred, nil
+}
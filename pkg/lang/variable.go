@@ -1,18 +1,35 @@
 package lang
 
 import (
+	"fmt"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
+// Variable is a variable block. A variable is set to the value given on the
+// command line, in a var file or in the environment, or to its default.
+// Without a type any value is accepted, a variable without a default must be
+// set. Validation blocks are checked once all variables are set.
 type Variable struct {
-	Name         string         `hcl:"name,label"`
-	Type         hcl.Expression `hcl:"type"`
-	DefaultValue hcl.Expression `hcl:"default"`
+	Name         string                `hcl:"name,label"`
+	Type         hcl.Expression        `hcl:"type,optional"`
+	DefaultValue hcl.Expression        `hcl:"default,optional"`
+	Description  string                `hcl:"description,optional"`
+	Nullable     *bool                 `hcl:"nullable,optional"`
+	Sensitive    bool                  `hcl:"sensitive,optional"`
+	Validations  []*VariableValidation `hcl:"validation,block"`
+	Body         hcl.Body              `hcl:",body"`
 	Value        cty.Value
 }
 
+type VariableValidation struct {
+	Condition    hcl.Expression `hcl:"condition"`
+	ErrorMessage string         `hcl:"error_message"`
+}
+
 type Variables []*Variable
 
 func (v Variables) GetMap() map[string]*Variable {
@@ -23,14 +40,95 @@ func (v Variables) GetMap() map[string]*Variable {
 	return varMap
 }
 
+// isSet reports whether an optional expression attribute was given. gohcl
+// sets missing attributes to a static null expression.
+func isSet(expr hcl.Expression) bool {
+	if expr == nil {
+		return false
+	}
+	if len(expr.Variables()) > 0 {
+		return true
+	}
+	val, diags := expr.Value(nil)
+	return diags.HasErrors() || !val.IsNull() || hcl.ExprAsKeyword(expr) == "null"
+}
+
+// TypeConstraint parses the type expression of the variable. Variables
+// without a type accept any value.
+func (v *Variable) TypeConstraint() (cty.Type, hcl.Diagnostics) {
+	if v.Type == nil || hcl.ExprAsKeyword(v.Type) == "" && !isSet(v.Type) {
+		return cty.DynamicPseudoType, nil
+	}
+	return typeexpr.TypeConstraint(v.Type)
+}
+
+func (v *Variable) IsNullable() bool {
+	return v.Nullable == nil || *v.Nullable
+}
+
+// convert converts val to the type of the variable, reporting errors at rng.
+func (v *Variable) convert(val cty.Value, rng hcl.Range) (cty.Value, hcl.Diagnostics) {
+	typ, diags := v.TypeConstraint()
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+	converted, err := convert.Convert(val, typ)
+	if err != nil {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("invalid value for variable %s", v.Name),
+			Detail:   fmt.Sprintf("The value is not compatible with the variable type %s: %s.", typ.FriendlyName(), err),
+			Subject:  rng.Ptr(),
+		}
+		return cty.DynamicVal, append(diags, diag)
+	}
+	return converted, diags
+}
+
 func (v *Variable) ApplyDefault(ctx *hcl.EvalContext) hcl.Diagnostics {
 	var diags hcl.Diagnostics
-	if v.Value.IsNull() {
-		val, moreDiags := v.DefaultValue.Value(ctx)
-		diags = append(diags, moreDiags...)
-		v.Value = val
+	if !v.Value.IsNull() || v.Value.Type() != cty.NilType && v.IsNullable() {
+		return diags
 	}
-
+	if !isSet(v.DefaultValue) {
+		if v.Value.Type() == cty.NilType {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("no value for required variable %s", v.Name),
+				Detail:   fmt.Sprintf("The variable %s has no default, so a value must be set with -var, a var file or %s%s.", v.Name, VariableEnvPrefix, v.Name),
+				Subject:  v.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		} else {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("variable %s must not be null", v.Name),
+				Detail:   "The variable is not nullable and has no default.",
+				Subject:  v.Body.MissingItemRange().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+		v.Value = cty.DynamicVal
+		return diags
+	}
+	val, moreDiags := v.DefaultValue.Value(ctx)
+	diags = append(diags, moreDiags...)
+	if moreDiags.HasErrors() {
+		v.Value = cty.DynamicVal
+		return diags
+	}
+	val, moreDiags = v.convert(val, v.DefaultValue.Range())
+	diags = append(diags, moreDiags...)
+	if val.IsNull() && !v.IsNullable() {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("variable %s must not be null", v.Name),
+			Detail:   "The variable is not nullable, so its default must not be null.",
+			Subject:  v.DefaultValue.Range().Ptr(),
+		}
+		diags = append(diags, diag)
+	}
+	v.Value = val
 	return diags
 }
 
@@ -43,6 +141,108 @@ func (v Variables) ApplyDefaults(ctx *hcl.EvalContext) hcl.Diagnostics {
 	return diags
 }
 
+// Validate checks the validation blocks of the variable. ctx must contain
+// the values of all variables.
+func (v *Variable) Validate(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, validation := range v.Validations {
+		val, moreDiags := validation.Condition.Value(ctx)
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			continue
+		}
+		val, err := convert.Convert(val, cty.Bool)
+		if err != nil || val.IsNull() || !val.IsKnown() {
+			diag := &hcl.Diagnostic{
+				Severity:    hcl.DiagError,
+				Summary:     "invalid validation condition",
+				Detail:      fmt.Sprintf("The condition of a validation of variable %s must be a known bool value.", v.Name),
+				Subject:     validation.Condition.Range().Ptr(),
+				Expression:  validation.Condition,
+				EvalContext: ctx,
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		if val.False() {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("invalid value for variable %s", v.Name),
+				Detail:   validation.ErrorMessage,
+				Subject:  validation.Condition.Range().Ptr(),
+			}
+			diags = append(diags, diag)
+		}
+	}
+	return diags
+}
+
+func (v Variables) Validate(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	ctx = ctx.NewChild()
+	ctx.Variables = map[string]cty.Value{"vars": cty.ObjectVal(v.GetCtyObject())}
+	for _, variable := range v {
+		moreDiags := variable.Validate(ctx)
+		diags = append(diags, moreDiags...)
+	}
+	return diags
+}
+
+// Sensitive returns the string values of all sensitive variables, including
+// the strings nested in collections.
+func (v Variables) Sensitive() Redactor {
+	var redactor Redactor
+	for _, variable := range v {
+		if variable.Sensitive {
+			redactor = redactor.Add(variable.Value)
+		}
+	}
+	return redactor
+}
+
+// ApplyValue sets the variable to value, converted to the type of the
+// variable.
+func (v *Variable) ApplyValue(value *VariableValue) hcl.Diagnostics {
+	typ, diags := v.TypeConstraint()
+	if diags.HasErrors() {
+		return diags
+	}
+	val, moreDiags := value.Value(typ)
+	diags = append(diags, moreDiags...)
+	if !moreDiags.HasErrors() {
+		v.Value = val
+	}
+	return diags
+}
+
+// ApplyValues sets every variable with an entry in values. Values for
+// undeclared variables are reported as an error if they are strict and as a
+// warning otherwise.
+func (v Variables) ApplyValues(values map[string]*VariableValue) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	varMap := v.GetMap()
+	for name, value := range values {
+		variable, ok := varMap[name]
+		if !ok {
+			severity := hcl.DiagWarning
+			if value.Strict {
+				severity = hcl.DiagError
+			}
+			diag := &hcl.Diagnostic{
+				Severity: severity,
+				Summary:  fmt.Sprintf("value for undeclared variable %s", name),
+				Detail:   fmt.Sprintf("A value was given for the variable %s, but it is not declared in the config.", name),
+				Subject:  value.Range.Ptr(),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		moreDiags := variable.ApplyValue(value)
+		diags = append(diags, moreDiags...)
+	}
+	return diags
+}
+
 func (v Variables) GetCtyObject() map[string]cty.Value {
 	varMap := make(map[string]cty.Value)
 	for _, variable := range v {
@@ -56,17 +256,20 @@ type VariableConfig struct {
 	Remain    hcl.Body  `hcl:",remain"`
 }
 
-func DecodeVariable(body hcl.Body, ctx *hcl.EvalContext) (
-	vars map[string]cty.Value, remain hcl.Body, diags hcl.Diagnostics,
+// DecodeVariable decodes the variable blocks of body and sets the variables
+// with an entry in values. Defaults are applied later by DecodeGlobals, since
+// they may reference locals and other variables.
+func DecodeVariable(body hcl.Body, ctx *hcl.EvalContext, values map[string]*VariableValue) (
+	variables Variables, remain hcl.Body, diags hcl.Diagnostics,
 ) {
 	var vari VariableConfig
 	moreDiags := gohcl.DecodeBody(body, ctx, &vari)
 	diags = append(diags, moreDiags...)
 
-	moreDiags = vari.Variables.ApplyDefaults(ctx)
+	moreDiags = vari.Variables.ApplyValues(values)
 	diags = append(diags, moreDiags...)
 
-	vars = vari.Variables.GetCtyObject()
+	variables = vari.Variables
 	remain = vari.Remain
 	return
 }
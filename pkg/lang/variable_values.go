@@ -0,0 +1,136 @@
+package lang
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"strings"
+)
+
+const VariableEnvPrefix = "OMEGA_PKG_VAR_"
+
+// VariableValue is a value for a variable given outside the config, either
+// as an expression from a var file or as raw text from a -var flag or an
+// environment variable.
+type VariableValue struct {
+	Name  string
+	Expr  hcl.Expression
+	Raw   string
+	Range hcl.Range
+	// Strict reports whether a value for an undeclared variable is an error.
+	Strict bool
+}
+
+// Value converts the value to typ. Raw text is taken literally for string
+// variables and parsed as an HCL expression otherwise, falling back to the
+// literal text for variables of any type. Expressions are evaluated without
+// variables or functions.
+func (v *VariableValue) Value(typ cty.Type) (cty.Value, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	expr := v.Expr
+	if expr == nil {
+		if typ == cty.String {
+			return cty.StringVal(v.Raw), diags
+		}
+		var moreDiags hcl.Diagnostics
+		expr, moreDiags = hclsyntax.ParseExpression([]byte(v.Raw), v.Range.Filename, hcl.InitialPos)
+		if !moreDiags.HasErrors() {
+			_, moreDiags = expr.Value(nil)
+		}
+		if moreDiags.HasErrors() && typ == cty.DynamicPseudoType {
+			return cty.StringVal(v.Raw), diags
+		}
+		diags = append(diags, moreDiags...)
+		if moreDiags.HasErrors() {
+			return cty.DynamicVal, diags
+		}
+	}
+	val, moreDiags := expr.Value(nil)
+	diags = append(diags, moreDiags...)
+	if moreDiags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+	val, err := convert.Convert(val, typ)
+	if err != nil {
+		diag := &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("invalid value for variable %s", v.Name),
+			Detail:   fmt.Sprintf("The value is not compatible with the variable type %s: %s.", typ.FriendlyName(), err),
+			Subject:  expr.Range().Ptr(),
+		}
+		diags = append(diags, diag)
+		return cty.DynamicVal, diags
+	}
+	return val, diags
+}
+
+// CollectVariableValues gathers the values for variables from the
+// environment, var files and -var flags. Later sources take precedence, so
+// the order is: OMEGA_PKG_VAR_<name> environment variables, var files in the
+// order given, -var flags in the order given.
+func CollectVariableValues(
+	parser *hclparse.Parser, environ []string, varFiles []string, varFlags []string,
+) (map[string]*VariableValue, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	values := make(map[string]*VariableValue)
+
+	for _, env := range environ {
+		if !strings.HasPrefix(env, VariableEnvPrefix) {
+			continue
+		}
+		name, raw, ok := strings.Cut(strings.TrimPrefix(env, VariableEnvPrefix), "=")
+		if !ok || name == "" {
+			continue
+		}
+		values[name] = &VariableValue{
+			Name: name,
+			Raw:  raw,
+			Range: hcl.Range{
+				Filename: fmt.Sprintf("<value for env %s%s>", VariableEnvPrefix, name),
+				Start:    hcl.InitialPos,
+				End:      hcl.InitialPos,
+			},
+		}
+	}
+
+	bodies, moreDiags := ParseConfigFiles(parser, varFiles)
+	diags = append(diags, moreDiags...)
+	for _, body := range bodies {
+		attrs, moreDiags := body.JustAttributes()
+		diags = append(diags, moreDiags...)
+		for name, attr := range attrs {
+			values[name] = &VariableValue{
+				Name:  name,
+				Expr:  attr.Expr,
+				Range: attr.Range,
+			}
+		}
+	}
+
+	for _, flag := range varFlags {
+		name, raw, ok := strings.Cut(flag, "=")
+		if !ok || name == "" {
+			diag := &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "invalid -var option",
+				Detail:   fmt.Sprintf("The given -var option %q is not correctly specified. It must be name=value.", flag),
+			}
+			diags = append(diags, diag)
+			continue
+		}
+		values[name] = &VariableValue{
+			Name: name,
+			Raw:  raw,
+			Range: hcl.Range{
+				Filename: fmt.Sprintf("<value for var %s>", name),
+				Start:    hcl.InitialPos,
+				End:      hcl.InitialPos,
+			},
+			Strict: true,
+		}
+	}
+	return values, diags
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const generationsDir = "generations"
+
+// Generation is the state of the packages after a successful run: every
+// installed package of every manager block with its version.
+type Generation struct {
+	ID       int                  `json:"id"`
+	Run      int                  `json:"run"`
+	Time     time.Time            `json:"time"`
+	Managers []*GenerationManager `json:"managers"`
+}
+
+// GenerationManager holds the installed packages of a manager block, by name.
+// CustomManager is the custom_manager the block resolved to. Managers with
+// scopes have an entry for every scope.
+type GenerationManager struct {
+	Name          string            `json:"name"`
+	CustomManager string            `json:"custom_manager"`
+	Scope         string            `json:"scope,omitempty"`
+	Packages      map[string]string `json:"packages"`
+}
+
+// Manager returns the packages of the manager block name, or nil.
+func (g *Generation) Manager(name string) *GenerationManager {
+	for _, manager := range g.Managers {
+		if manager.Name == name {
+			return manager
+		}
+	}
+	return nil
+}
+
+// SaveGeneration writes generation to the store, numbering it after the last
+// generation.
+func (s *Store) SaveGeneration(generation *Generation) error {
+	dir := filepath.Join(s.Dir, generationsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "create state directory")
+	}
+	ids, err := s.ids(generationsDir)
+	if err != nil {
+		return err
+	}
+	generation.ID = 1
+	if len(ids) > 0 {
+		generation.ID = ids[len(ids)-1] + 1
+	}
+	return writeJSON(filepath.Join(dir, strconv.Itoa(generation.ID)+jsonFile), generation)
+}
+
+// Generations returns the IDs of all generations, oldest first.
+func (s *Store) Generations() ([]int, error) {
+	return s.ids(generationsDir)
+}
+
+// Generation reads the generation id.
+func (s *Store) Generation(id int) (*Generation, error) {
+	generation := new(Generation)
+	if err := readJSON(filepath.Join(s.Dir, generationsDir, strconv.Itoa(id)+jsonFile), generation); err != nil {
+		return nil, errors.Wrapf(err, "read generation %d", id)
+	}
+	return generation, nil
+}
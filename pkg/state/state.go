@@ -0,0 +1,201 @@
+package state
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DirName  = "omega-pkg"
+	runsDir  = "runs"
+	jsonFile = ".json"
+)
+
+// DefaultDir returns the state directory, $XDG_STATE_HOME/omega-pkg or
+// ~/.local/state/omega-pkg if XDG_STATE_HOME is not set.
+func DefaultDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, DirName), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "get home directory")
+	}
+	return filepath.Join(home, ".local", "state", DirName), nil
+}
+
+// Store keeps the records of past runs and the generations of the installed
+// packages as JSON files in a directory.
+type Store struct {
+	Dir string
+}
+
+func Open(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Run is the record of a run of omega-pkg. Generation is the generation
+// recorded after the run succeeded.
+type Run struct {
+	ID         int        `json:"id"`
+	Command    string     `json:"command"`
+	Start      time.Time  `json:"start"`
+	End        time.Time  `json:"end"`
+	ConfigHash string     `json:"config_hash"`
+	Error      string     `json:"error,omitempty"`
+	Generation int        `json:"generation,omitempty"`
+	Commands   []*Command `json:"commands"`
+}
+
+// Command is a command executed during a run. Manager and Action tell which
+// manager block and action it belongs to, Set the location of the set that
+// ran it and Packages the packages it changed.
+type Command struct {
+	Manager  string        `json:"manager,omitempty"`
+	Action   string        `json:"action"`
+	Set      string        `json:"set,omitempty"`
+	Packages []string      `json:"packages,omitempty"`
+	Argv     []string      `json:"argv"`
+	ExitCode int           `json:"exit_code"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+}
+
+func NewRun(command, configHash string) *Run {
+	return &Run{Command: command, Start: time.Now(), ConfigHash: configHash}
+}
+
+// Record adds command to the run.
+func (r *Run) Record(command *Command) {
+	r.Commands = append(r.Commands, command)
+}
+
+// Finish sets the end of the run and the error it failed with, if any.
+func (r *Run) Finish(err error) {
+	r.End = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Changed returns the packages changed by the commands of the run.
+func (r *Run) Changed() []string {
+	var changed []string
+	for _, command := range r.Commands {
+		changed = append(changed, command.Packages...)
+	}
+	return changed
+}
+
+// Status returns "ok", "failed" or "running" if the run did not finish.
+func (r *Run) Status() string {
+	switch {
+	case r.Error != "":
+		return "failed"
+	case r.End.IsZero():
+		return "running"
+	default:
+		return "ok"
+	}
+}
+
+// SaveRun writes run to the store, numbering it after the last run if it has
+// no ID yet.
+func (s *Store) SaveRun(run *Run) error {
+	dir := filepath.Join(s.Dir, runsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "create state directory")
+	}
+	if run.ID == 0 {
+		ids, err := s.ids(runsDir)
+		if err != nil {
+			return err
+		}
+		run.ID = 1
+		if len(ids) > 0 {
+			run.ID = ids[len(ids)-1] + 1
+		}
+	}
+	return writeJSON(filepath.Join(dir, strconv.Itoa(run.ID)+jsonFile), run)
+}
+
+// Runs returns all runs of the store, oldest first.
+func (s *Store) Runs() ([]*Run, error) {
+	ids, err := s.ids(runsDir)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*Run, 0, len(ids))
+	for _, id := range ids {
+		run, err := s.Run(id)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// Run reads the run id.
+func (s *Store) Run(id int) (*Run, error) {
+	run := new(Run)
+	if err := readJSON(filepath.Join(s.Dir, runsDir, strconv.Itoa(id)+jsonFile), run); err != nil {
+		return nil, errors.Wrapf(err, "read run %d", id)
+	}
+	return run, nil
+}
+
+// ids lists the numbered JSON files of the subdirectory name in ascending
+// order. A missing directory has none.
+func (s *Store) ids(name string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read state directory %s", name)
+	}
+	var ids []int
+	for _, entry := range entries {
+		id, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), jsonFile))
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), jsonFile) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// writeJSON writes v to path through a temporary file, so a crash never
+// leaves a partial file behind.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s", filepath.Base(path))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrapf(err, "write %s", filepath.Base(path))
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "rename %s", filepath.Base(path))
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrapf(err, "unmarshal %s", filepath.Base(path))
+	}
+	return nil
+}